@@ -0,0 +1,282 @@
+// Package diff implements the Myers line diff algorithm and unified diff formatting.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OpType is a kind of a line edit.
+type OpType int
+
+const (
+	Equal OpType = iota
+	Insert
+	Delete
+)
+
+// Edit is a single line edit turning one text into another. Lines keep their line terminators.
+type Edit struct {
+	Op   OpType
+	Line string
+}
+
+// Hunk is a group of edits surrounded by unchanged context lines. Line numbers are 1-based,
+// or 0 when a hunk covers no lines of a respective side.
+type Hunk struct {
+	FromLine  int
+	FromCount int
+	ToLine    int
+	ToCount   int
+	Edits     []Edit
+}
+
+// DefaultContext is a number of unchanged lines shown around changes.
+const DefaultContext = 3
+
+// SplitLines splits a text into lines keeping line terminators, so a missing trailing newline
+// is a difference too.
+func SplitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines returns the shortest edit script turning a into b using the linear space variant of
+// the Myers algorithm. The middle snake of the edit path is searched from both ends at once and
+// texts around it are diffed recursively, so memory stays proportional to the texts length.
+func Lines(a, b []string) []Edit {
+	return diffLines(nil, a, b)
+}
+
+// diffLines appends edits turning a into b to a given edit script.
+func diffLines(edits []Edit, a, b []string) []Edit {
+	// common prefix and suffix are kept as they are
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		edits = append(edits, Edit{Op: Equal, Line: a[prefix]})
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-suffix-1] == b[len(b)-suffix-1] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			edits = append(edits, Edit{Op: Insert, Line: line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			edits = append(edits, Edit{Op: Delete, Line: line})
+		}
+	default:
+		x, y := middleSnake(a, b)
+		edits = diffLines(edits, a[:x], b[:y])
+		edits = diffLines(edits, a[x:], b[y:])
+	}
+
+	for _, line := range common {
+		edits = append(edits, Edit{Op: Equal, Line: line})
+	}
+	return edits
+}
+
+// middleSnake returns a point the shortest edit path turning a into b goes through, so that
+// both parts of the path around it are shorter than the whole one. Furthest reaching paths
+// are followed from the beginning and from the end of the texts until they overlap. Both texts
+// are not empty and differ in their first and last lines.
+func middleSnake(a, b []string) (int, int) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	// furthest x reached on every diagonal k = x - y, backward paths count x from the end
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - m
+	// paths overlap on forward steps when the texts lengths differ by an odd number
+	odd := delta%2 != 0
+	// diagonals running out of the edit graph are not followed anymore
+	var fStart, fEnd, bStart, bEnd int
+
+	for d := 0; d < maxD; d++ {
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case odd:
+				if bk := offset + delta - k; bk >= 0 && bk < len(backward) && backward[bk] != -1 && x >= n-backward[bk] {
+					return x, y
+				}
+			}
+		}
+
+		for k := -d + bStart; k <= d-bEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !odd:
+				if fk := offset + delta - k; fk >= 0 && fk < len(forward) && forward[fk] != -1 {
+					fx := forward[fk]
+					if fx >= n-x {
+						return fx, fx - (fk - offset)
+					}
+				}
+			}
+		}
+	}
+
+	// paths always overlap, a plain replacement is the fallback anyway
+	return n, 0
+}
+
+// Hunks groups edits into hunks with a given number of context lines. Hunks which context
+// lines overlap are merged.
+func Hunks(edits []Edit, context int) []Hunk {
+	// line positions of both sides before every edit
+	fromPos := make([]int, len(edits)+1)
+	toPos := make([]int, len(edits)+1)
+	for i, e := range edits {
+		fromPos[i+1], toPos[i+1] = fromPos[i], toPos[i]
+		if e.Op != Insert {
+			fromPos[i+1]++
+		}
+		if e.Op != Delete {
+			toPos[i+1]++
+		}
+	}
+
+	var hunks []Hunk
+	i := 0
+
+	for i < len(edits) {
+		for i < len(edits) && edits[i].Op == Equal {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		end := i
+		for {
+			for end < len(edits) && edits[end].Op != Equal {
+				end++
+			}
+			next := end
+			for next < len(edits) && edits[next].Op == Equal {
+				next++
+			}
+			if next < len(edits) && next-end <= 2*context {
+				end = next
+				continue
+			}
+			end += context
+			if end > len(edits) {
+				end = len(edits)
+			}
+			break
+		}
+
+		h := Hunk{
+			FromLine:  fromPos[start],
+			FromCount: fromPos[end] - fromPos[start],
+			ToLine:    toPos[start],
+			ToCount:   toPos[end] - toPos[start],
+			Edits:     edits[start:end],
+		}
+		if h.FromCount > 0 {
+			h.FromLine++
+		}
+		if h.ToCount > 0 {
+			h.ToLine++
+		}
+		hunks = append(hunks, h)
+
+		i = end
+	}
+
+	return hunks
+}
+
+// Unified returns a unified diff of two texts or an empty string if they are equal.
+func Unified(fromName, toName, a, b string) string {
+	hunks := Hunks(Lines(SplitLines(a), SplitLines(b)), DefaultContext)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+	for _, h := range hunks {
+		sb.WriteString(h.String())
+	}
+	return sb.String()
+}
+
+// String formats a hunk the unified diff way.
+func (h Hunk) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", h.FromLine, h.FromCount, h.ToLine, h.ToCount)
+	for _, e := range h.Edits {
+		switch e.Op {
+		case Equal:
+			sb.WriteString(" ")
+		case Insert:
+			sb.WriteString("+")
+		case Delete:
+			sb.WriteString("-")
+		}
+		sb.WriteString(e.Line)
+		if !strings.HasSuffix(e.Line, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,115 @@
+package diff
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	cases := []struct {
+		a, b string
+	}{
+		{"", ""},
+		{"", "a\nb\n"},
+		{"a\nb\n", ""},
+		{"a\nb\nc\n", "a\nb\nc\n"},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n"},
+		{"one\ntwo\nthree", "one\n2\nthree\nfour\n"},
+	}
+
+	for _, c := range cases {
+		a, b := SplitLines(c.a), SplitLines(c.b)
+		edits := Lines(a, b)
+
+		var from, to []string
+		changes := 0
+		for _, e := range edits {
+			switch e.Op {
+			case Equal:
+				from = append(from, e.Line)
+				to = append(to, e.Line)
+			case Delete:
+				from = append(from, e.Line)
+				changes++
+			case Insert:
+				to = append(to, e.Line)
+				changes++
+			}
+		}
+
+		if strings.Join(from, "") != c.a || strings.Join(to, "") != c.b {
+			t.Fatalf("edits %v do not turn %q into %q", edits, c.a, c.b)
+		}
+		if c.a == c.b && changes != 0 {
+			t.Fatalf("expected no changes for equal texts, got %v", edits)
+		}
+	}
+
+	// the classic Myers paper example has the shortest edit script of length 5
+	edits := Lines(SplitLines("a\nb\nc\na\nb\nb\na\n"), SplitLines("c\nb\na\nb\na\nc\n"))
+	changes := 0
+	for _, e := range edits {
+		if e.Op != Equal {
+			changes++
+		}
+	}
+	if changes != 5 {
+		t.Fatalf("expected 5 changes, got %v", changes)
+	}
+
+	// a full rewrite of a long file is the longest edit script there is
+	var a, b []string
+	for i := 0; i < 4000; i++ {
+		a = append(a, "old "+strconv.Itoa(i)+"\n")
+		b = append(b, "new "+strconv.Itoa(i)+"\n")
+	}
+	if edits := Lines(a, b); len(edits) != 8000 || edits[0].Op != Delete || edits[7999].Op != Insert {
+		t.Fatalf("expected every line to be replaced, got %d edits", len(edits))
+	}
+}
+
+func TestUnified(t *testing.T) {
+	var before, after []string
+	for i := 1; i <= 20; i++ {
+		line := strings.Repeat("x", i) + "\n"
+		before = append(before, line)
+		if i == 2 || i == 18 {
+			line = "changed\n"
+		}
+		after = append(after, line)
+	}
+
+	patch := Unified("a/file", "b/file", strings.Join(before, ""), strings.Join(after, ""))
+	expected := `--- a/file
++++ b/file
+@@ -1,5 +1,5 @@
+ x
+-xx
++changed
+ xxx
+ xxxx
+ xxxxx
+@@ -15,6 +15,6 @@
+ xxxxxxxxxxxxxxx
+ xxxxxxxxxxxxxxxx
+ xxxxxxxxxxxxxxxxx
+-xxxxxxxxxxxxxxxxxx
++changed
+ xxxxxxxxxxxxxxxxxxx
+ xxxxxxxxxxxxxxxxxxxx
+`
+	if patch != expected {
+		t.Fatalf("expected patch:\n%s\ngot:\n%s", expected, patch)
+	}
+
+	patch = Unified("/dev/null", "b/file", "", "new")
+	expected = "--- /dev/null\n+++ b/file\n@@ -0,0 +1,1 @@\n+new\n\\ No newline at end of file\n"
+	if patch != expected {
+		t.Fatalf("expected patch:\n%s\ngot:\n%s", expected, patch)
+	}
+
+	if patch := Unified("a", "b", "same\n", "same\n"); patch != "" {
+		t.Fatalf("expected no patch for equal texts, got %q", patch)
+	}
+}
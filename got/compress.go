@@ -0,0 +1,260 @@
+package got
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Object compression codecs.
+const (
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+	CodecNone = "none"
+)
+
+// DefaultCompression is a compression objects are written with unless the repo config sets another one.
+var DefaultCompression = Compression{Codec: CodecGzip, Level: gzip.DefaultCompression}
+
+// Compression is an object compression codec along with its level.
+type Compression struct {
+	Codec string
+	Level int
+}
+
+// ParseCompression parses a codec name optionally followed by a colon and a level,
+// e.g. "gzip:9", "zstd" or "none".
+func ParseCompression(s string) (Compression, error) {
+	name, levelStr := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, levelStr = s[:i], s[i+1:]
+	}
+
+	var c Compression
+	switch strings.ToLower(name) {
+	case CodecGzip:
+		c = Compression{Codec: CodecGzip, Level: gzip.DefaultCompression}
+	case CodecZstd:
+		c = Compression{Codec: CodecZstd, Level: 3}
+	case CodecNone:
+		c = Compression{Codec: CodecNone}
+	default:
+		return Compression{}, fmt.Errorf("unknown compression codec %q", name)
+	}
+
+	if levelStr != "" {
+		level, err := strconv.Atoi(levelStr)
+		if err != nil {
+			return Compression{}, fmt.Errorf("invalid compression level %q", levelStr)
+		}
+		c.Level = level
+	}
+	if err := c.validate(); err != nil {
+		return Compression{}, err
+	}
+	return c, nil
+}
+
+// String returns a compression in the form ParseCompression accepts.
+func (c Compression) String() string {
+	if c.Codec == CodecNone {
+		return c.Codec
+	}
+	return c.Codec + ":" + strconv.Itoa(c.Level)
+}
+
+func (c Compression) validate() error {
+	switch c.Codec {
+	case CodecGzip:
+		if c.Level < gzip.HuffmanOnly || c.Level > gzip.BestCompression {
+			return fmt.Errorf("gzip compression level %d is out of range %d..%d", c.Level, gzip.HuffmanOnly, gzip.BestCompression)
+		}
+	case CodecZstd:
+		if c.Level < 1 || c.Level > 22 {
+			return fmt.Errorf("zstd compression level %d is out of range 1..22", c.Level)
+		}
+	case CodecNone:
+		if c.Level != 0 {
+			return errors.New("no compression has no levels")
+		}
+	default:
+		return fmt.Errorf("unknown compression codec %q", c.Codec)
+	}
+	return nil
+}
+
+// archiveMagic starts archives of codecs other than gzip. Gzip archives keep archive header
+// fields in the gzip header, other archives have them in the got header following the magic: a codec
+// byte, uvarint length prefixed name and comment and varint modification time in unix nanoseconds.
+// Codec is recorded in every archive, so objects written with different codecs live side by side.
+const archiveMagic = "GOTA"
+
+const (
+	codecByteNone byte = 'n'
+	codecByteZstd byte = 'z'
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// encodeArchive returns an archive of object data compressed with a given compression.
+func encodeArchive(obj *RawObject, c Compression) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if c.Codec == CodecGzip {
+		archiver, err := gzip.NewWriterLevel(&buf, c.Level)
+		if err != nil {
+			return nil, err
+		}
+		archiver.Name = obj.Name
+		archiver.ModTime = obj.ModTime
+		archiver.Comment = obj.Comment
+		if _, err := archiver.Write(obj.Data); err != nil {
+			return nil, err
+		}
+		if err := archiver.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	buf.WriteString(archiveMagic)
+	switch c.Codec {
+	case CodecNone:
+		buf.WriteByte(codecByteNone)
+	case CodecZstd:
+		buf.WriteByte(codecByteZstd)
+	default:
+		return nil, fmt.Errorf("unknown compression codec %q", c.Codec)
+	}
+	var num [binary.MaxVarintLen64]byte
+	for _, s := range []string{obj.Name, obj.Comment} {
+		buf.Write(num[:binary.PutUvarint(num[:], uint64(len(s)))])
+		buf.WriteString(s)
+	}
+	var mtime int64
+	if !obj.ModTime.IsZero() {
+		mtime = obj.ModTime.UnixNano()
+	}
+	buf.Write(num[:binary.PutVarint(num[:], mtime)])
+
+	if c.Codec == CodecZstd {
+		return zstdEncoder(c.Level).EncodeAll(obj.Data, buf.Bytes()), nil
+	}
+	buf.Write(obj.Data)
+	return buf.Bytes(), nil
+}
+
+// decodeArchive unpacks an archive of any codec, the name is used in errors only.
+func decodeArchive(r io.Reader, name string) (*RawObject, error) {
+	archive, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading archive %s: %w", name, err)
+	}
+
+	if !bytes.HasPrefix(archive, []byte(archiveMagic)) {
+		return decodeGzipArchive(archive, name)
+	}
+
+	br := bytes.NewReader(archive[len(archiveMagic):])
+	codec, err := br.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("reading archive %s: %w", name, err)
+	}
+	obj := &RawObject{}
+	for _, field := range []*string{&obj.Name, &obj.Comment} {
+		n, err := binary.ReadUvarint(br)
+		if err != nil || n > uint64(br.Len()) {
+			return nil, fmt.Errorf("reading archive %s: invalid header", name)
+		}
+		s := make([]byte, n)
+		br.Read(s)
+		*field = string(s)
+	}
+	mtime, err := binary.ReadVarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading archive %s: invalid header", name)
+	}
+	if mtime != 0 {
+		obj.ModTime = time.Unix(0, mtime)
+	}
+
+	payload := archive[len(archive)-br.Len():]
+	switch codec {
+	case codecByteNone:
+		obj.Data = payload
+	case codecByteZstd:
+		if obj.Data, err = zstdDecoder().DecodeAll(payload, nil); err != nil {
+			return nil, fmt.Errorf("reading archive %s: %w", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("reading archive %s: unknown codec %q: %w", name, codec, ErrUnsupportedFormat)
+	}
+	return obj, nil
+}
+
+// decodeGzipArchive unpacks a gzip archive.
+func decodeGzipArchive(archive []byte, name string) (*RawObject, error) {
+	unarchiver, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("reading archive %s: %w", name, err)
+	}
+	defer unarchiver.Close()
+	res, err := ioutil.ReadAll(unarchiver)
+	if err != nil {
+		return nil, fmt.Errorf("reading archive %s: %w", name, err)
+	}
+	return &RawObject{
+		Name:    unarchiver.Name,
+		Comment: unarchiver.Comment,
+		ModTime: unarchiver.ModTime,
+		Data:    res,
+	}, nil
+}
+
+// archiveCodec returns a codec an archive was compressed with.
+func archiveCodec(archive []byte) string {
+	switch {
+	case bytes.HasPrefix(archive, gzipMagic):
+		return CodecGzip
+	case bytes.HasPrefix(archive, []byte(archiveMagic+string(codecByteZstd))):
+		return CodecZstd
+	case bytes.HasPrefix(archive, []byte(archiveMagic+string(codecByteNone))):
+		return CodecNone
+	default:
+		return ""
+	}
+}
+
+var (
+	zstdEncoders sync.Map
+	zstdDec      *zstd.Decoder
+	zstdDecOnce  sync.Once
+)
+
+// zstdEncoder returns a shared encoder of a given level, EncodeAll is safe for concurrent use.
+func zstdEncoder(level int) *zstd.Encoder {
+	if enc, ok := zstdEncoders.Load(level); ok {
+		return enc.(*zstd.Encoder)
+	}
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	actual, _ := zstdEncoders.LoadOrStore(level, enc)
+	return actual.(*zstd.Encoder)
+}
+
+// zstdDecoder returns a shared decoder, DecodeAll is safe for concurrent use.
+func zstdDecoder() *zstd.Decoder {
+	zstdDecOnce.Do(func() {
+		zstdDec, _ = zstd.NewReader(nil)
+	})
+	return zstdDec
+}
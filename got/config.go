@@ -0,0 +1,457 @@
+package got
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SystemConfigPath is a path of the system wide config file.
+var SystemConfigPath = "/etc/gotconfig"
+
+// userConfigName is a user config file name in the home dir.
+const userConfigName = ".gotconfig"
+
+// UserConfigPath returns a path of the user config file kept in the home dir, or an empty
+// string when the home dir is unknown.
+func UserConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, userConfigName)
+}
+
+// ConfigScope is a config file level. Values of the repo config override user ones, and user
+// values override system ones.
+type ConfigScope int
+
+const (
+	ScopeSystem ConfigScope = iota + 1
+	ScopeUser
+	ScopeRepo
+)
+
+// String returns a scope name.
+func (s ConfigScope) String() string {
+	switch s {
+	case ScopeSystem:
+		return "system"
+	case ScopeUser:
+		return "user"
+	case ScopeRepo:
+		return "repo"
+	default:
+		return "invalid"
+	}
+}
+
+// ConfigEntry is a single config value.
+type ConfigEntry struct {
+	Scope ConfigScope
+	Name  string
+	Value string
+}
+
+// Config is a merged view of system, user and repo config files. Names are "section.key"
+// strings matched case insensitively.
+type Config struct {
+	files []*ConfigFile
+}
+
+// Config reads system, user and repo config files. Missing files are treated as empty.
+func (r *Repository) Config() (*Config, error) {
+	return ReadConfig(r.ConfigPath())
+}
+
+// ReadConfig reads system and user config files along with a given repo config file, which could
+// be empty outside of a repo.
+func ReadConfig(repoConfigPath string) (*Config, error) {
+	c := &Config{}
+	for _, l := range []struct {
+		scope ConfigScope
+		path  string
+	}{{ScopeSystem, SystemConfigPath}, {ScopeUser, UserConfigPath()}, {ScopeRepo, repoConfigPath}} {
+		if l.path == "" {
+			continue
+		}
+		f, err := ReadConfigFile(l.path)
+		if err != nil {
+			return nil, err
+		}
+		f.Scope = l.scope
+		c.files = append(c.files, f)
+	}
+	return c, nil
+}
+
+// Get returns a value set by the most specific config file. Repo format values are read from
+// the repo config only, the same way the repo is opened.
+func (c *Config) Get(name string) (string, bool) {
+	for i := len(c.files) - 1; i >= 0; i-- {
+		if c.files[i].Scope != ScopeRepo && IsFormatConfig(name) {
+			continue
+		}
+		if v, ok := c.files[i].Get(name); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// String returns a string value or a given default one.
+func (c *Config) String(name, def string) string {
+	if v, ok := c.Get(name); ok {
+		return v
+	}
+	return def
+}
+
+// Bool returns a boolean value or a given default one. True, yes, on and 1 are true values,
+// false, no, off and 0 are false ones.
+func (c *Config) Bool(name string, def bool) (bool, error) {
+	v, ok := c.Get(name)
+	if !ok {
+		return def, nil
+	}
+	switch strings.ToLower(v) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s = %q is not a boolean: %w", name, v, ErrInvalidConfigValue)
+	}
+}
+
+// Int returns an integer value or a given default one.
+func (c *Config) Int(name string, def int) (int, error) {
+	v, ok := c.Get(name)
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s = %q is not an integer: %w", name, v, ErrInvalidConfigValue)
+	}
+	return n, nil
+}
+
+// Duration returns a duration value, e.g. 720h, or a given default one.
+func (c *Config) Duration(name string, def time.Duration) (time.Duration, error) {
+	v, ok := c.Get(name)
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s = %q is not a duration: %w", name, v, ErrInvalidConfigValue)
+	}
+	return d, nil
+}
+
+// Path returns a file path value with a leading ~/ expanded into the home dir, or a given
+// default one.
+func (c *Config) Path(name, def string) string {
+	v, ok := c.Get(name)
+	if !ok {
+		return def
+	}
+	if strings.HasPrefix(v, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, v[2:])
+		}
+	}
+	return v
+}
+
+// List returns values of all the config files, less specific files go first. Repo format values
+// of system and user configs are skipped, they have no effect.
+func (c *Config) List() []ConfigEntry {
+	var entries []ConfigEntry
+	for _, f := range c.files {
+		for _, e := range f.List() {
+			if f.Scope == ScopeRepo || !IsFormatConfig(e.Name) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries
+}
+
+// loadSettings applies config values kept by the repo handle.
+func (r *Repository) loadSettings() error {
+	config, err := r.Config()
+	if err != nil {
+		return err
+	}
+	if r.Workers, err = config.Int("core.workers", 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// IgnoreEntries returns default ignore entries followed by patterns of a file set by
+// core.excludesfile, which keeps patterns ignored in every repo of a user.
+func (r *Repository) IgnoreEntries() ([]string, error) {
+	config, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	entries := append([]string(nil), DefaultIgnoreEntries...)
+
+	p := config.Path("core.excludesfile", "")
+	if p == "" {
+		return entries, nil
+	}
+	contents, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read excludes file: %w", err)
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+	return entries, nil
+}
+
+// ConfigFile is a single ini config file. Comments and formatting of a file are kept
+// when values are changed.
+type ConfigFile struct {
+	Scope ConfigScope
+
+	path  string
+	lines []configLine
+}
+
+// configLine is a config file line. Section is set for section headers and values, key
+// for values only.
+type configLine struct {
+	raw     string
+	section string
+	key     string
+	value   string
+}
+
+// ReadConfigFile reads an ini config file. A missing file is read as an empty one.
+func ReadConfigFile(p string) (*ConfigFile, error) {
+	f := &ConfigFile{path: p}
+	contents, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var section string
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for n := 1; scanner.Scan(); n++ {
+		l := configLine{raw: scanner.Text()}
+		line := strings.TrimSpace(l.raw)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			l.section = section
+		default:
+			if section == "" {
+				return nil, fmt.Errorf("read config %s:%d: value %q out of section", p, n, line)
+			}
+			l.section = section
+			// a key without a value is a true boolean
+			key := line
+			if i := strings.IndexAny(key, "#;"); i >= 0 {
+				key = strings.TrimSpace(key[:i])
+			}
+			l.key, l.value = strings.ToLower(key), "true"
+			if eq := strings.IndexByte(line, '='); eq >= 0 {
+				l.key = strings.ToLower(strings.TrimSpace(line[:eq]))
+				if l.value, err = parseConfigValue(strings.TrimSpace(line[eq+1:])); err != nil {
+					return nil, fmt.Errorf("read config %s:%d: %w", p, n, err)
+				}
+			}
+		}
+		f.lines = append(f.lines, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	return f, nil
+}
+
+// Path returns the config file path.
+func (f *ConfigFile) Path() string {
+	return f.path
+}
+
+// Get returns a value, the last one wins when it is set several times.
+func (f *ConfigFile) Get(name string) (string, bool) {
+	section, key, err := splitConfigName(name)
+	if err != nil {
+		return "", false
+	}
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		if l := f.lines[i]; l.key == key && l.section == section {
+			return l.value, true
+		}
+	}
+	return "", false
+}
+
+// Set sets a value. It replaces the last existing value or is added to the end of its section,
+// the section is added when missing.
+func (f *ConfigFile) Set(name, value string) error {
+	section, key, err := splitConfigName(name)
+	if err != nil {
+		return err
+	}
+	l := configLine{raw: "\t" + key + " = " + formatConfigValue(value), section: section, key: key, value: value}
+
+	existing, sectionEnd := -1, -1
+	for i, line := range f.lines {
+		if line.section == section {
+			sectionEnd = i
+			if line.key == key {
+				existing = i
+			}
+		}
+	}
+	switch {
+	case existing >= 0:
+		f.lines[existing] = l
+	case sectionEnd >= 0:
+		f.lines = append(f.lines[:sectionEnd+1], append([]configLine{l}, f.lines[sectionEnd+1:]...)...)
+	default:
+		f.lines = append(f.lines, configLine{raw: "[" + section + "]", section: section}, l)
+	}
+	return nil
+}
+
+// Unset removes all the values of a name and reports whether there were any.
+func (f *ConfigFile) Unset(name string) (bool, error) {
+	section, key, err := splitConfigName(name)
+	if err != nil {
+		return false, err
+	}
+	lines := f.lines[:0]
+	var found bool
+	for _, l := range f.lines {
+		if l.key == key && l.section == section {
+			found = true
+			continue
+		}
+		lines = append(lines, l)
+	}
+	f.lines = lines
+	return found, nil
+}
+
+// List returns the file values in the order they are written.
+func (f *ConfigFile) List() []ConfigEntry {
+	var entries []ConfigEntry
+	for _, l := range f.lines {
+		if l.key != "" {
+			entries = append(entries, ConfigEntry{Scope: f.Scope, Name: l.section + "." + l.key, Value: l.value})
+		}
+	}
+	return entries
+}
+
+// Write writes the config file atomically.
+func (f *ConfigFile) Write() error {
+	var buf bytes.Buffer
+	for _, l := range f.lines {
+		buf.WriteString(l.raw)
+		buf.WriteByte('\n')
+	}
+	if err := writeFileAtomic(f.path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+// formatConfigNames are config values describing the repo format. They are changed by got
+// commands converting the repo, since setting them alone would make the repo unreadable.
+var formatConfigNames = []string{"core.repositoryformatversion", "core.compression", "extensions."}
+
+// IsFormatConfig reports whether a config name is one of the repo format values.
+func IsFormatConfig(name string) bool {
+	name = strings.ToLower(name)
+	for _, n := range formatConfigNames {
+		if name == n || (strings.HasSuffix(n, ".") && strings.HasPrefix(name, n)) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitConfigName splits a "section.key" name into a lower case section and key. Sections
+// could have dots in them, keys could not.
+func splitConfigName(name string) (string, string, error) {
+	name = strings.ToLower(name)
+	dot := strings.LastIndexByte(name, '.')
+	if dot <= 0 || dot == len(name)-1 {
+		return "", "", fmt.Errorf("%w %q, expected section.key", ErrInvalidConfigName, name)
+	}
+	section, key := name[:dot], name[dot+1:]
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return "", "", fmt.Errorf("%w %q, keys consist of letters, digits and dashes", ErrInvalidConfigName, name)
+		}
+	}
+	if strings.ContainsAny(section, "[]\n") {
+		return "", "", fmt.Errorf("%w %q", ErrInvalidConfigName, name)
+	}
+	return section, key, nil
+}
+
+// parseConfigValue unquotes a double quoted value and strips a trailing comment started by
+// # or ; outside of quotes.
+func parseConfigValue(v string) (string, error) {
+	if !strings.HasPrefix(v, `"`) {
+		if i := strings.IndexAny(v, "#;"); i >= 0 {
+			v = strings.TrimSpace(v[:i])
+		}
+		return v, nil
+	}
+
+	end := -1
+	for i := 1; i < len(v) && end < 0; i++ {
+		switch v[i] {
+		case '\\':
+			i++
+		case '"':
+			end = i
+		}
+	}
+	if end < 0 {
+		return "", fmt.Errorf("%w %s", ErrInvalidConfigValue, v)
+	}
+	if rest := strings.TrimSpace(v[end+1:]); rest != "" && rest[0] != '#' && rest[0] != ';' {
+		return "", fmt.Errorf("%w %s", ErrInvalidConfigValue, v)
+	}
+	s, err := strconv.Unquote(v[:end+1])
+	if err != nil {
+		return "", fmt.Errorf("%w %s", ErrInvalidConfigValue, v)
+	}
+	return s, nil
+}
+
+// formatConfigValue quotes a value which would not be read back as is otherwise.
+func formatConfigValue(v string) string {
+	if v == "" || v != strings.TrimSpace(v) || strings.ContainsAny(v, "\"#;\n\\") {
+		return strconv.Quote(v)
+	}
+	return v
+}
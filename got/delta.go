@@ -0,0 +1,229 @@
+package got
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// deltaBlock is a size of base chunks matches are looked up by.
+	deltaBlock = 16
+	// deltaWindow is a number of previous versions of a file a blob is tried against.
+	deltaWindow = 10
+	// maxDeltaDepth limits delta chains, so reading a blob never applies too many deltas.
+	maxDeltaDepth = 10
+
+	deltaCopy   byte = 'c'
+	deltaInsert byte = 'i'
+)
+
+var errInvalidDelta = errors.New("invalid delta")
+
+// makeDelta returns instructions building target from base. Delta starts with base and target
+// sizes followed by copy instructions holding a base offset and length, and insert instructions
+// holding a length and literal bytes. Numbers are uvarints.
+func makeDelta(base, target []byte) []byte {
+	blocks := make(map[string]int)
+	for i := 0; i+deltaBlock <= len(base); i += deltaBlock {
+		key := string(base[i : i+deltaBlock])
+		if _, ok := blocks[key]; !ok {
+			blocks[key] = i
+		}
+	}
+
+	var delta []byte
+	delta = appendUvarint(delta, uint64(len(base)))
+	delta = appendUvarint(delta, uint64(len(target)))
+
+	var insert []byte
+	flush := func() {
+		if len(insert) > 0 {
+			delta = append(delta, deltaInsert)
+			delta = appendUvarint(delta, uint64(len(insert)))
+			delta = append(delta, insert...)
+			insert = insert[:0]
+		}
+	}
+
+	for i := 0; i < len(target); {
+		offset, ok := -1, false
+		if i+deltaBlock <= len(target) {
+			offset, ok = blocks[string(target[i:i+deltaBlock])]
+		}
+		if !ok {
+			insert = append(insert, target[i])
+			i++
+			continue
+		}
+
+		// extend the match backwards over pending literals and forwards as far as it goes
+		for offset > 0 && len(insert) > 0 && base[offset-1] == insert[len(insert)-1] {
+			offset--
+			i--
+			insert = insert[:len(insert)-1]
+		}
+		length := 0
+		for offset+length < len(base) && i+length < len(target) && base[offset+length] == target[i+length] {
+			length++
+		}
+
+		flush()
+		delta = append(delta, deltaCopy)
+		delta = appendUvarint(delta, uint64(offset))
+		delta = appendUvarint(delta, uint64(length))
+		i += length
+	}
+	flush()
+
+	return delta
+}
+
+// applyDelta builds target content from base and delta instructions.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	baseSize, err := binary.ReadUvarint(r)
+	if err != nil || baseSize != uint64(len(base)) {
+		return nil, fmt.Errorf("%w: base size mismatch", errInvalidDelta)
+	}
+	targetSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidDelta, err)
+	}
+
+	target := make([]byte, 0, targetSize)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		switch op {
+		case deltaCopy:
+			offset, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", errInvalidDelta, err)
+			}
+			length, err := binary.ReadUvarint(r)
+			if err != nil || offset+length > uint64(len(base)) {
+				return nil, fmt.Errorf("%w: copy out of base bounds", errInvalidDelta)
+			}
+			target = append(target, base[offset:offset+length]...)
+		case deltaInsert:
+			length, err := binary.ReadUvarint(r)
+			if err != nil || length > uint64(r.Len()) {
+				return nil, fmt.Errorf("%w: insert out of delta bounds", errInvalidDelta)
+			}
+			literal := make([]byte, length)
+			r.Read(literal)
+			target = append(target, literal...)
+		default:
+			return nil, fmt.Errorf("%w: unknown instruction %q", errInvalidDelta, op)
+		}
+	}
+
+	if uint64(len(target)) != targetSize {
+		return nil, fmt.Errorf("%w: target size mismatch", errInvalidDelta)
+	}
+	return target, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// packObject is an object being packed. Archive is its full archive when it is known
+// already, so it is not compressed again.
+type packObject struct {
+	objType string
+	hash    string
+	raw     *RawObject
+	archive []byte
+
+	// name and time are where a blob comes from, blobs are grouped by them for delta encoding
+	name string
+	time time.Time
+
+	base  *packObject
+	delta []byte
+	depth int
+}
+
+// pickDeltas chooses delta bases for blobs. Blobs are grouped by file name and ordered by time,
+// so versions of the same file made across commits are compared. Every blob is tried against
+// a few previous versions and is delta encoded against the one giving the smallest delta, in case
+// it is at most half of the blob size and compresses smaller than the full blob with a given compression.
+func pickDeltas(objs []*packObject, c Compression) error {
+	groups := make(map[string][]*packObject)
+	var names []string
+	for _, o := range objs {
+		if o.objType != "blob" || o.raw == nil {
+			continue
+		}
+		o.name, o.time = o.raw.Name, o.raw.ModTime
+		// blobs with object headers keep their name and time in the header
+		if o.name == "" {
+			if h, _, err := DecodeObject(o.raw.Data); err == nil {
+				o.name, o.time = h.Name, h.Time
+			}
+		}
+		if _, ok := groups[o.name]; !ok {
+			names = append(names, o.name)
+		}
+		groups[o.name] = append(groups[o.name], o)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		group := groups[name]
+		sort.SliceStable(group, func(i, j int) bool {
+			if !group[i].time.Equal(group[j].time) {
+				return group[i].time.Before(group[j].time)
+			}
+			return group[i].hash < group[j].hash
+		})
+
+		for i, o := range group {
+			target := o.raw.Data
+			for j := i - 1; j >= 0 && j >= i-deltaWindow; j-- {
+				base := group[j]
+				if base.depth >= maxDeltaDepth || !similarSize(len(base.raw.Data), len(target)) {
+					continue
+				}
+				delta := makeDelta(base.raw.Data, target)
+				if len(delta) > len(target)/2 || (o.delta != nil && len(delta) >= len(o.delta)) {
+					continue
+				}
+				o.base, o.delta, o.depth = base, delta, base.depth+1
+			}
+			if o.base == nil {
+				continue
+			}
+
+			if o.archive == nil {
+				full, err := encodeArchive(o.raw, c)
+				if err != nil {
+					return err
+				}
+				o.archive = full
+			}
+			deltaArchive, err := encodeArchive(&RawObject{Name: o.raw.Name, Comment: o.raw.Comment, ModTime: o.raw.ModTime, Data: o.delta}, c)
+			if err != nil {
+				return err
+			}
+			if len(deltaArchive) >= len(o.archive) {
+				o.base, o.delta, o.depth = nil, nil, 0
+				continue
+			}
+			o.archive = deltaArchive
+		}
+	}
+
+	return nil
+}
+
+// similarSize tells whether two blobs sizes differ no more than twice.
+func similarSize(a, b int) bool {
+	return a <= 2*b && b <= 2*a
+}
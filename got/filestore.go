@@ -0,0 +1,459 @@
+package got
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FileStore is a default object store keeping every object as a separate archive
+// in objects/{commit,tree,blob,tag} directories. Every archive records its codec: gzip archives
+// are plain gzip files, zstd and uncompressed ones start with a got archive header naming
+// the codec, so archives of different codecs live side by side. Object metadata is kept in
+// headers inside the object data, archive header fields are only filled for repos not migrated
+// to object headers. Repack moves archives into a single pack under objects/pack, objects are
+// looked up among loose archives first and then in packs. A rewrite of every object is staged
+// as a pack under objects/pack/staged until it is published.
+type FileStore struct {
+	dir         string
+	compression Compression
+
+	mu          sync.Mutex
+	packs       []*pack
+	packsLoaded bool
+}
+
+// NewFileStore returns a store keeping objects under a given objects directory.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir, compression: DefaultCompression}
+}
+
+// SetCompression sets a compression new archives are written with.
+func (s *FileStore) SetCompression(c Compression) {
+	s.compression = c
+}
+
+// Get reads an object archive.
+func (s *FileStore) Get(objType, hash string) (*RawObject, error) {
+	obj, err := readArchive(s.objPath(objType, hash))
+	if !errors.Is(err, ErrObjDoesNotExist) {
+		return obj, err
+	}
+
+	p, e, ok, packErr := s.findPacked(objType, hash)
+	if packErr != nil {
+		return nil, packErr
+	}
+	if !ok {
+		return nil, err
+	}
+	return s.readPacked(p, e)
+}
+
+// readPacked reads a packed object. Delta encoded objects are rebuilt from their base objects.
+func (s *FileStore) readPacked(p *pack, e packEntry) (*RawObject, error) {
+	archive, err := p.read(e)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := decodeArchive(bytes.NewReader(archive), p.name+":"+e.objType+"/"+e.hash)
+	if err != nil || e.base == "" {
+		return obj, err
+	}
+
+	base, err := s.Get(e.objType, e.base)
+	if err != nil {
+		return nil, fmt.Errorf("read %s %s delta base: %w", e.objType, e.hash, err)
+	}
+	if obj.Data, err = applyDelta(base.Data, obj.Data); err != nil {
+		return nil, fmt.Errorf("read %s %s from pack %s: %w", e.objType, e.hash, p.name, err)
+	}
+	return obj, nil
+}
+
+// Put writes an object archive. Object type dir is created when missing, so repos inited
+// before a new object type was introduced keep working.
+func (s *FileStore) Put(objType, hash string, obj *RawObject) error {
+	if err := os.MkdirAll(filepath.Join(s.dir, objType), 0755); err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+	return writeArchive(s.objPath(objType, hash), obj, s.compression)
+}
+
+// Has tests whether an object archive exists either loose or packed.
+func (s *FileStore) Has(objType, hash string) (bool, error) {
+	if _, err := os.Stat(s.objPath(objType, hash)); err == nil {
+		return true, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	_, _, ok, err := s.findPacked(objType, hash)
+	return ok, err
+}
+
+// Delete removes a loose object archive. Packed objects are dropped by Repack.
+func (s *FileStore) Delete(objType, hash string) error {
+	if err := os.Remove(s.objPath(objType, hash)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("delete archive: %w", err)
+	}
+	return nil
+}
+
+// Iterate walks over loose and packed objects of a given type in hash order.
+func (s *FileStore) Iterate(objType string, fn func(hash string) error) error {
+	hashes, err := s.looseHashes(objType)
+	if err != nil {
+		return fmt.Errorf("iterate %s objects: %w", objType, err)
+	}
+
+	packs, err := s.loadedPacks()
+	if err != nil {
+		return fmt.Errorf("iterate %s objects: %w", objType, err)
+	}
+	for _, p := range packs {
+		for _, e := range p.entries {
+			if e.objType == objType {
+				hashes = append(hashes, e.hash)
+			}
+		}
+	}
+	sort.Strings(hashes)
+
+	for i, hash := range hashes {
+		// an object could be both loose and packed
+		if i > 0 && hashes[i-1] == hash {
+			continue
+		}
+		if err := fn(hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Repack moves loose and packed objects accepted by opts.Keep into a single new pack and removes
+// loose archives and older packs. Objects which are not accepted are dropped. Similar versions
+// of blobs are delta encoded against each other, with opts.Recompress every archive is rewritten
+// with the store compression. It returns a number of packed objects.
+func (s *FileStore) Repack(opts RepackOptions) (int, error) {
+	keep := opts.Keep
+	if keep == nil {
+		keep = func(objType, hash string) bool { return true }
+	}
+
+	objs, loose, packs, err := s.readAll(keep, opts.Recompress)
+	if err != nil {
+		return 0, fmt.Errorf("repack: %w", err)
+	}
+	if opts.Recompress {
+		for _, o := range objs {
+			o.archive = nil
+		}
+	}
+
+	var packed *pack
+	if len(objs) > 0 {
+		if packed, err = s.writeObjects(filepath.Join(s.dir, packDir), objs); err != nil {
+			return 0, fmt.Errorf("repack: %w", err)
+		}
+	}
+
+	if err := s.removeObjects(packs, loose, packed); err != nil {
+		return 0, fmt.Errorf("repack: %w", err)
+	}
+	return len(objs), nil
+}
+
+// StageRewrite reads every object rebuilding delta encoded ones, passes them through fn and writes
+// the results with the store compression into a pack under objects/pack/staged, which is not read
+// until PublishStaged moves it to the other packs. Nothing is written when fn fails.
+func (s *FileStore) StageRewrite(fn func(objType, hash string, obj *RawObject) (*RawObject, error)) (int, error) {
+	all := func(objType, hash string) bool { return true }
+	objs, _, _, err := s.readAll(all, true)
+	if err != nil {
+		return 0, fmt.Errorf("stage rewrite: %w", err)
+	}
+	for _, o := range objs {
+		if o.raw, err = fn(o.objType, o.hash, o.raw); err != nil {
+			return 0, fmt.Errorf("stage rewrite: %w", err)
+		}
+		o.archive = nil
+	}
+
+	dir := s.stagedDir()
+	if err := os.RemoveAll(dir); err != nil {
+		return 0, fmt.Errorf("stage rewrite: %w", err)
+	}
+	if len(objs) == 0 {
+		return 0, nil
+	}
+	if _, err := s.writeObjects(dir, objs); err != nil {
+		return 0, fmt.Errorf("stage rewrite: %w", err)
+	}
+	return len(objs), nil
+}
+
+// Staged reports whether there is a staged pack.
+func (s *FileStore) Staged() (bool, error) {
+	staged, err := loadPacks(s.stagedDir())
+	if err != nil {
+		return false, err
+	}
+	return len(staged) > 0, nil
+}
+
+// PublishStaged replaces loose archives and packs with the staged pack. The pack data is moved
+// first and the index goes last, after older objects are removed, so an interrupted publish is
+// finished by calling it again.
+func (s *FileStore) PublishStaged() error {
+	staged, err := loadPacks(s.stagedDir())
+	if err != nil {
+		return fmt.Errorf("publish staged: %w", err)
+	}
+
+	for _, p := range staged {
+		published := &pack{name: p.name, dataPath: filepath.Join(s.dir, packDir, p.name+".pack"), idxPath: filepath.Join(s.dir, packDir, p.name+".idx")}
+		if err := os.Rename(p.dataPath, published.dataPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("publish staged: %w", err)
+		}
+
+		s.mu.Lock()
+		s.packs, s.packsLoaded = nil, false
+		s.mu.Unlock()
+		packs, err := s.loadedPacks()
+		if err != nil {
+			return fmt.Errorf("publish staged: %w", err)
+		}
+		loose, err := s.loosePaths()
+		if err != nil {
+			return fmt.Errorf("publish staged: %w", err)
+		}
+		if err := s.removeObjects(packs, loose, published); err != nil {
+			return fmt.Errorf("publish staged: %w", err)
+		}
+
+		if err := os.Rename(p.idxPath, published.idxPath); err != nil {
+			return fmt.Errorf("publish staged: %w", err)
+		}
+	}
+
+	if err := os.RemoveAll(s.stagedDir()); err != nil {
+		return fmt.Errorf("publish staged: %w", err)
+	}
+	s.mu.Lock()
+	s.packs, s.packsLoaded = nil, false
+	s.mu.Unlock()
+	return nil
+}
+
+// readAll reads loose and packed objects accepted by keep along with paths of every loose archive
+// and every pack. Delta encoded objects are rebuilt, their bases could be dropped. Blobs and, with
+// decode, every other object are decoded too.
+func (s *FileStore) readAll(keep func(objType, hash string) bool, decode bool) ([]*packObject, []string, []*pack, error) {
+	packs, err := s.loadedPacks()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	loose, err := s.loosePaths()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var objs []*packObject
+	seen := make(map[packEntry]bool)
+	for _, path := range loose {
+		objType, hash := filepath.Base(filepath.Dir(path)), filepath.Base(path)
+		if !keep(objType, hash) {
+			continue
+		}
+		o := &packObject{objType: objType, hash: hash}
+		if o.archive, err = ioutil.ReadFile(path); err != nil {
+			return nil, nil, nil, err
+		}
+		objs = append(objs, o)
+		seen[packEntry{objType: objType, hash: hash}] = true
+	}
+
+	for _, p := range packs {
+		for _, e := range p.entries {
+			key := packEntry{objType: e.objType, hash: e.hash}
+			if seen[key] || !keep(e.objType, e.hash) {
+				continue
+			}
+			seen[key] = true
+			o := &packObject{objType: e.objType, hash: e.hash}
+			if e.base != "" {
+				o.raw, err = s.readPacked(p, e)
+			} else {
+				o.archive, err = p.read(e)
+			}
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			objs = append(objs, o)
+		}
+	}
+
+	for _, o := range objs {
+		if (o.objType == "blob" || decode) && o.raw == nil {
+			if o.raw, err = decodeArchive(bytes.NewReader(o.archive), o.objType+"/"+o.hash); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	return objs, loose, packs, nil
+}
+
+// writeObjects delta encodes similar blobs, archives objects which have no archive with the store
+// compression and writes them into a new pack in a given dir.
+func (s *FileStore) writeObjects(dir string, objs []*packObject) (*pack, error) {
+	if err := pickDeltas(objs, s.compression); err != nil {
+		return nil, err
+	}
+	var err error
+	for _, o := range objs {
+		if o.archive == nil {
+			if o.archive, err = encodeArchive(o.raw, s.compression); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return writePack(dir, objs)
+}
+
+// removeObjects removes packs and loose archives except a given pack, which could be nil.
+func (s *FileStore) removeObjects(packs []*pack, loose []string, except *pack) error {
+	s.mu.Lock()
+	s.packs, s.packsLoaded = nil, false
+	s.mu.Unlock()
+
+	for _, p := range packs {
+		if except != nil && p.name == except.name {
+			continue
+		}
+		if err := p.remove(); err != nil {
+			return err
+		}
+	}
+	for _, path := range loose {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// loosePaths returns paths of every loose archive.
+func (s *FileStore) loosePaths() ([]string, error) {
+	typeDirs, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, fi := range typeDirs {
+		if !fi.IsDir() || fi.Name() == packDir {
+			continue
+		}
+		hashes, err := s.looseHashes(fi.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range hashes {
+			paths = append(paths, s.objPath(fi.Name(), hash))
+		}
+	}
+	return paths, nil
+}
+
+// stagedDir returns a dir a rewrite is staged in.
+func (s *FileStore) stagedDir() string {
+	return filepath.Join(s.dir, packDir, stagedDir)
+}
+
+// looseHashes returns hashes of loose archives of a given type.
+func (s *FileStore) looseHashes(objType string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(s.dir, objType))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for _, fi := range entries {
+		// skip temp files left by interrupted writes
+		if !fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+			hashes = append(hashes, fi.Name())
+		}
+	}
+	return hashes, nil
+}
+
+// loadedPacks returns store packs loading their indexes on the first call.
+func (s *FileStore) loadedPacks() ([]*pack, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.packsLoaded {
+		packs, err := loadPacks(filepath.Join(s.dir, packDir))
+		if err != nil {
+			return nil, err
+		}
+		s.packs, s.packsLoaded = packs, true
+	}
+	return s.packs, nil
+}
+
+// findPacked looks an object up in the store packs.
+func (s *FileStore) findPacked(objType, hash string) (*pack, packEntry, bool, error) {
+	packs, err := s.loadedPacks()
+	if err != nil {
+		return nil, packEntry{}, false, err
+	}
+	for _, p := range packs {
+		if e, ok := p.find(objType, hash); ok {
+			return p, e, true, nil
+		}
+	}
+	return nil, packEntry{}, false, nil
+}
+
+// objPath returns an archive path for an object.
+func (s *FileStore) objPath(objType, hash string) string {
+	return filepath.Join(s.dir, objType, hash)
+}
+
+// writeArchive implements archive writing for object data. Archive is written into a temp file
+// and renamed, so an interrupted write never leaves a truncated object.
+func writeArchive(p string, obj *RawObject, c Compression) error {
+	archive, err := encodeArchive(obj, c)
+	if err != nil {
+		return fmt.Errorf("writing archive %s: %w", p, err)
+	}
+	if err := writeFileAtomic(p, archive, 0644); err != nil {
+		return fmt.Errorf("writing archive %s: %w", p, err)
+	}
+	return nil
+}
+
+// readArchive reads an archive of any codec and returns its content along with header fields.
+func readArchive(p string) (*RawObject, error) {
+	fd, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrObjDoesNotExist
+		}
+		return nil, fmt.Errorf("reading archive %s: %w", p, err)
+	}
+	defer fd.Close()
+	return decodeArchive(fd, p)
+}
@@ -0,0 +1,199 @@
+package got
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// FormatVersion is the newest repo format version this got understands. Version 0 repos keep
+// SHA-1 gzip objects with metadata in archive header fields, version 1 repos record their object
+// format, compression codec and object headers in the config extensions section.
+const FormatVersion = 1
+
+var configPath string = path.Join(gotPath, "config")
+
+// knownExtensions are config extensions this got understands.
+var knownExtensions = map[string]bool{
+	"objectformat":  true,
+	"compression":   true,
+	"objectheaders": true,
+}
+
+// ConfigPath returns absolute repo config file path.
+func (r *Repository) ConfigPath() string {
+	return r.path(configPath)
+}
+
+// SetCompression sets a compression new objects are written with and records it in the repo config.
+// Existing objects are kept as they are, every archive records its codec. Codecs other than gzip
+// make the repo a version 1 one, so got versions unable to read them refuse the repo, and stay
+// declared until ForgetOtherCodecs is called.
+func (r *Repository) SetCompression(c Compression) error {
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("set compression: %w", err)
+	}
+	r.Compression = c
+	if c.Codec != CodecGzip && !r.declaresCodec(c.Codec) {
+		r.codecs = append(r.codecs, c.Codec)
+	}
+	if cs, ok := r.Store.(Compressor); ok {
+		cs.SetCompression(c)
+	}
+	if err := r.writeFormat(); err != nil {
+		return fmt.Errorf("set compression: %w", err)
+	}
+	return nil
+}
+
+// ForgetOtherCodecs records that every object is compressed with the repo compression codec, so
+// other codecs are not declared in the repo config anymore. It is called once every object
+// is recompressed.
+func (r *Repository) ForgetOtherCodecs() error {
+	r.codecs = nil
+	if r.Compression.Codec != CodecGzip {
+		r.codecs = []string{r.Compression.Codec}
+	}
+	if err := r.writeFormat(); err != nil {
+		return fmt.Errorf("forget codecs: %w", err)
+	}
+	return nil
+}
+
+// declaresCodec reports whether the repo config declares a codec.
+func (r *Repository) declaresCodec(codec string) bool {
+	for _, c := range r.codecs {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// EnableObjectHeaders records in the repo config that objects keep their metadata in headers.
+// It is called once every object of the repo is migrated.
+func (r *Repository) EnableObjectHeaders() error {
+	r.ObjectHeaders = true
+	if err := r.writeFormat(); err != nil {
+		return fmt.Errorf("enable object headers: %w", err)
+	}
+	return nil
+}
+
+// writeFormat records the repo format version, object format and compression in the repo config.
+// Other config values are kept.
+func (r *Repository) writeFormat() error {
+	f, err := ReadConfigFile(r.ConfigPath())
+	if err != nil {
+		return err
+	}
+
+	version := 0
+	if r.Hash.Name != SHA1.Name || len(r.codecs) > 0 || r.ObjectHeaders {
+		version = 1
+	}
+	values := []struct {
+		name  string
+		value string
+		set   bool
+	}{
+		{"core.repositoryformatversion", strconv.Itoa(version), true},
+		{"core.compression", r.Compression.String(), r.Compression != DefaultCompression},
+		{"extensions.objectformat", r.Hash.Name, version > 0},
+		{"extensions.compression", strings.Join(r.codecs, ","), len(r.codecs) > 0},
+		{"extensions.objectheaders", "true", version > 0 && r.ObjectHeaders},
+	}
+	for _, v := range values {
+		if v.set {
+			err = f.Set(v.name, v.value)
+		} else {
+			_, err = f.Unset(v.name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return f.Write()
+}
+
+// loadFormat reads the repo format from the repo config and sets the repo hash algorithm and
+// compression. Repos without a config are version 0 ones. Newer format versions and unknown
+// extensions are refused, so an older got never misreads a repo. Format values are read from
+// the repo config only, user and system configs could not change them.
+func (r *Repository) loadFormat() error {
+	r.Hash = SHA1
+	r.Compression = DefaultCompression
+	r.codecs = nil
+	r.ObjectHeaders = false
+
+	f, err := ReadConfigFile(r.ConfigPath())
+	if err != nil {
+		return err
+	}
+
+	version := 0
+	if v, ok := f.Get("core.repositoryformatversion"); ok {
+		if version, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("%w: invalid format version %q", ErrUnsupportedFormat, v)
+		}
+	}
+	if version > FormatVersion {
+		return fmt.Errorf("%w: repo format version %d is newer than version %d supported by this got, upgrade got to open the repo", ErrUnsupportedFormat, version, FormatVersion)
+	}
+	if version == 0 {
+		return r.loadCompression(f)
+	}
+
+	for _, e := range f.List() {
+		name := strings.TrimPrefix(e.Name, "extensions.")
+		if name == e.Name {
+			continue
+		}
+		if !knownExtensions[name] {
+			return fmt.Errorf("%w: unknown repo extension %q, upgrade got to open the repo", ErrUnsupportedFormat, name)
+		}
+		switch name {
+		case "objectformat":
+			if r.Hash, err = HashAlgorithmByName(e.Value); err != nil {
+				return err
+			}
+		case "objectheaders":
+			if r.ObjectHeaders, err = strconv.ParseBool(e.Value); err != nil {
+				return fmt.Errorf("%w: invalid objectheaders extension value %q", ErrUnsupportedFormat, e.Value)
+			}
+		}
+	}
+	return r.loadCompression(f)
+}
+
+// loadCompression sets the repo compression from core.compression and codecs declared by the
+// compression extension, a comma separated list of codecs other than gzip objects could be
+// compressed with. The repo compression codec is accepted only when it is declared.
+func (r *Repository) loadCompression(f *ConfigFile) error {
+	if ext, ok := f.Get("extensions.compression"); ok {
+		for _, codec := range strings.Split(ext, ",") {
+			if _, err := ParseCompression(codec); err != nil {
+				return fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+			}
+			r.codecs = append(r.codecs, codec)
+		}
+	}
+
+	v, ok := f.Get("core.compression")
+	if !ok {
+		return nil
+	}
+	c, err := ParseCompression(v)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	if c.Codec != CodecGzip && !r.declaresCodec(c.Codec) {
+		return fmt.Errorf("%w: %s compression is not declared in repo extensions, use got config set core.compression to change it", ErrUnsupportedFormat, c.Codec)
+	}
+	r.Compression = c
+	if cs, ok := r.Store.(Compressor); ok {
+		cs.SetCompression(c)
+	}
+	return nil
+}
@@ -3,22 +3,37 @@ package got
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"time"
 )
 
 var (
-	ErrRepoAlreadyInited = errors.New("repo already initialized")
-	ErrInvalidObjType    = errors.New("invalid object type")
-	ErrNotGotRepo        = errors.New("not a got repo")
-	ErrWrongRootType     = errors.New("only commit could be an object graph root")
-	ErrWrongLogEntryType = errors.New("only commit could be saved in repo logs")
-	ErrObjDoesNotExist   = errors.New("object does not exist")
+	ErrRepoAlreadyInited  = errors.New("repo already initialized")
+	ErrInvalidObjType     = errors.New("invalid object type")
+	ErrNotGotRepo         = errors.New("not a got repo")
+	ErrWrongRootType      = errors.New("only commit could be an object graph root")
+	ErrWrongLogEntryType  = errors.New("only commit could be saved in repo logs")
+	ErrObjDoesNotExist    = errors.New("object does not exist")
+	ErrUnknownRevision    = errors.New("unknown revision")
+	ErrInvalidRefName     = errors.New("invalid ref name")
+	ErrBranchExists       = errors.New("branch already exists")
+	ErrBranchCheckedOut   = errors.New("branch is checked out")
+	ErrTagExists          = errors.New("tag already exists")
+	ErrNoMatchingPath     = errors.New("path did not match any files")
+	ErrRepoLocked         = errors.New("repo is locked")
+	ErrLocalChanges       = errors.New("worktree has uncommitted changes")
+	ErrRepoCorrupt        = errors.New("repo is corrupt")
+	ErrUnsupportedFormat  = errors.New("unsupported repo format")
+	ErrNoConfigValue      = errors.New("config value is not set")
+	ErrInvalidConfigName  = errors.New("invalid config name")
+	ErrInvalidConfigValue = errors.New("invalid config value")
 )
 
 var DefaultIgnoreEntries = []string{
@@ -29,8 +44,8 @@ var DefaultIgnoreEntries = []string{
 	".DS_Store",
 }
 
-var AbsRepoRoot string
-
+// EmptyCommitRef is a commit hash a branch without commits resolves into in SHA-1 repos.
+// Use Repository.EmptyRef to get one for the repo object format.
 var EmptyCommitRef = []byte("0000000000000000000000000000000000000000")
 
 var (
@@ -38,146 +53,263 @@ var (
 	objectsPath string = path.Join(gotPath, "objects")
 	headPath    string = path.Join(gotPath, "HEAD")
 	logPath     string = path.Join(gotPath, "LOG")
+	refsPath    string = path.Join(gotPath, "refs")
+	headsPath   string = path.Join(refsPath, "heads")
+	tagsPath    string = path.Join(refsPath, "tags")
 
 	CommitPath string = path.Join(objectsPath, "commit")
 	TreePath   string = path.Join(objectsPath, "tree")
 	BlobPath   string = path.Join(objectsPath, "blob")
+	TagPath    string = path.Join(objectsPath, "tag")
 
 	logsHeader string = "Time\t\t\tCommit hash\t\t\t\t\tParent hash\t\t\t\t\tCommit message\n"
 )
 
-// InitRepo initializes a repo in a current working directory by creating a .got dir with all the needing content.
-func InitRepo() {
-	if _, err := os.Stat(gotPath); !os.IsNotExist(err) {
-		log.Fatal(ErrRepoAlreadyInited)
+// Repository is a handle to a got repo. It owns the repo root path and the object store
+// located under it, so several repositories could be operated on in one process.
+type Repository struct {
+	Root  string
+	Store ObjectStore
+	// Hash is an algorithm objects are named by, it is recorded in the repo config at init.
+	Hash HashAlgorithm
+	// Compression is a compression new objects are written with, it is set by core.compression
+	// in the repo config.
+	Compression Compression
+	// codecs are codecs other than gzip objects of the repo could be compressed with, they are
+	// declared by extensions.compression in the repo config.
+	codecs []string
+	// ObjectHeaders tells whether object metadata is kept in headers in front of the object body.
+	// Repos inited before headers were introduced keep it in archive header fields until migrated.
+	ObjectHeaders bool
+	// Workers limits a number of goroutines hashing and writing objects concurrently,
+	// zero means GOMAXPROCS. It is set by core.workers in the config.
+	Workers int
+}
+
+// Init initializes a repo in a given directory by creating a .got dir with all the needing content.
+// Objects of the repo are named by SHA-1 hashes.
+func Init(p string) (*Repository, error) {
+	return InitWithHash(p, SHA1)
+}
+
+// InitWithHash initializes a repo which objects are named by a given hash algorithm. The algorithm
+// is recorded in the repo config along with the repo format version.
+func InitWithHash(p string, algo HashAlgorithm) (*Repository, error) {
+	root, err := filepath.Abs(p)
+	if err != nil {
+		return nil, fmt.Errorf("init repo: %w", err)
+	}
+	r := newRepository(root)
+	r.Hash = algo
+	r.ObjectHeaders = true
+
+	if _, err := os.Stat(r.gotDir()); !os.IsNotExist(err) {
+		return nil, ErrRepoAlreadyInited
+	}
+
+	for _, dir := range []string{gotPath, objectsPath, CommitPath, TreePath, BlobPath, TagPath, refsPath, headsPath, tagsPath} {
+		if err := os.Mkdir(r.path(dir), 0755); err != nil {
+			return nil, fmt.Errorf("init repo: %w", err)
+		}
+	}
+
+	if err := r.writeFormat(); err != nil {
+		return nil, fmt.Errorf("init repo: %w", err)
+	}
+
+	if err := r.SetHeadBranch(DefaultBranch); err != nil {
+		return nil, fmt.Errorf("init repo: %w", err)
 	}
 
-	err := os.Mkdir(gotPath, 0755)
-	err = os.Mkdir(objectsPath, 0755)
-	err = os.Mkdir(CommitPath, 0755)
-	err = os.Mkdir(TreePath, 0755)
-	err = os.Mkdir(BlobPath, 0755)
-	err = ioutil.WriteFile(headPath, EmptyCommitRef, 0644)
-	_, err = os.Create(logPath)
+	f, err := os.Create(r.LogPath())
+	if err != nil {
+		return nil, fmt.Errorf("init repo: %w", err)
+	}
+
+	return r, f.Close()
+}
 
+// Open opens a repo which root is exactly the given directory.
+func Open(p string) (*Repository, error) {
+	root, err := filepath.Abs(p)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("open repo: %w", err)
+	}
+
+	isRoot, err := isRepoRoot(root)
+	if err != nil {
+		return nil, fmt.Errorf("open repo: %w", err)
+	}
+	if !isRoot {
+		return nil, fmt.Errorf("open repo %s: %w", root, ErrNotGotRepo)
+	}
+
+	r := newRepository(root)
+	if err := r.load(); err != nil {
+		return nil, fmt.Errorf("open repo %s: %w", root, err)
+	}
+	return r, nil
+}
+
+// Discover finds closer .got dir in a given dir and its parent paths and opens the repo there.
+func Discover(cwd string) (*Repository, error) {
+	p, err := filepath.Abs(cwd)
+	if err != nil {
+		return nil, fmt.Errorf("discover repo: %w", err)
+	}
+
+	for {
+		isRoot, err := isRepoRoot(p)
+		if err != nil {
+			return nil, fmt.Errorf("discover repo: %w", err)
+		}
+		if isRoot {
+			r := newRepository(p)
+			if err := r.load(); err != nil {
+				return nil, fmt.Errorf("open repo %s: %w", p, err)
+			}
+			return r, nil
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return nil, ErrNotGotRepo
+		}
+		p = parent
 	}
 }
 
-// SetRepoRoot finds closer .got dir in parent paths and sets its absolute path into an exported variable.
-func SetRepoRoot() {
-	AbsRepoRoot = getRepoRoot()
+// newRepository returns a repo handle with a default file object store.
+func newRepository(root string) *Repository {
+	r := &Repository{Root: root, Hash: SHA1, Compression: DefaultCompression}
+	r.Store = NewFileStore(r.path(objectsPath))
+	return r
 }
 
-// CommitDirAbsPath returns absolute path holding commit objects.
-func CommitDirAbsPath() string {
-	return path.Join(AbsRepoRoot, CommitPath)
+// load reads the repo format and settings from the config. Objects staged by a migration
+// interrupted after it has recorded object headers are published first, holding the repo lock.
+func (r *Repository) load() error {
+	if err := r.loadFormat(); err != nil {
+		return err
+	}
+	if rw, ok := r.Store.(Rewriter); ok && r.ObjectHeaders {
+		staged, err := rw.Staged()
+		if err != nil {
+			return err
+		}
+		if staged {
+			if err := r.WithLock(rw.PublishStaged); err != nil {
+				return err
+			}
+		}
+	}
+	return r.loadSettings()
+}
+
+// EmptyRef returns a commit hash a branch without commits resolves into.
+func (r *Repository) EmptyRef() string {
+	return r.Hash.EmptyRef()
 }
 
-// TreeDirAbsPath returns absolute path holding tree objects.
-func TreeDirAbsPath() string {
-	return path.Join(AbsRepoRoot, TreePath)
+// Parallelism returns a number of goroutines objects are hashed and written with.
+func (r *Repository) Parallelism() int {
+	if r.Workers > 0 {
+		return r.Workers
+	}
+	return runtime.GOMAXPROCS(0)
 }
 
-// BlobDirAbsPath returns absolute path holding blob objects.
-func BlobDirAbsPath() string {
-	return path.Join(AbsRepoRoot, BlobPath)
+// HeadPath returns absolute HEAD file path.
+func (r *Repository) HeadPath() string {
+	return r.path(headPath)
 }
 
-// HeadsAbsPath returns absolute HEAD file path.
-func HeadAbsPath() string {
-	return path.Join(AbsRepoRoot, headPath)
+// LogPath returns absolute LOG file path.
+func (r *Repository) LogPath() string {
+	return r.path(logPath)
 }
 
-// HeadsAbsPath returns absolute LOG file path.
-func LogAbsPath() string {
-	return path.Join(AbsRepoRoot, logPath)
+// gotDir returns absolute .got dir path.
+func (r *Repository) gotDir() string {
+	return r.path(gotPath)
+}
+
+// path joins repo relative path with the repo root.
+func (r *Repository) path(rel string) string {
+	return filepath.Join(r.Root, rel)
 }
 
 // ReadLog reads all LOG file contents, reverses it for the right historical order, ads headers
 // and returns the result as a string.
-func ReadLog() string {
-	contents, err := ioutil.ReadFile(LogAbsPath())
+func (r *Repository) ReadLog() (string, error) {
+	contents, err := ioutil.ReadFile(r.LogPath())
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("read log: %w", err)
 	}
 	withHeaders := string(contents) + logsHeader
 	entries := strings.Split(withHeaders, "\n")
 	sort.Sort(sort.Reverse(sort.StringSlice(entries)))
 	logs := strings.Join(entries, "\n")
-	return logs
-}
-
-// UpdateLog adds a log entry into a LOG file.
-func UpdateLog(entry string) {
-	f, err := os.OpenFile(LogAbsPath(), os.O_APPEND|os.O_WRONLY, 0644)
-	defer f.Close()
-	_, err = f.WriteString(entry)
-
-	if err != nil {
-		log.Fatal(err)
-	}
+	return logs, nil
 }
 
-// UpdateHead refresh last commit hash string in a HEAD file.
-func UpdateHead(sha string) {
-	if err := ioutil.WriteFile(HeadAbsPath(), []byte(sha), 0644); err != nil {
-		log.Fatal(err)
-	}
+// LogEntry is a single commit record of a LOG file.
+type LogEntry struct {
+	Time       time.Time
+	Hash       string
+	ParentHash string
+	Message    string
 }
 
-// ReadHead reads commit hash string from a HEAD file.
-func ReadHead() string {
-	commitSha, err := ioutil.ReadFile(HeadAbsPath())
+// ReadLogEntries parses LOG file records in the order they were written.
+func (r *Repository) ReadLogEntries() ([]LogEntry, error) {
+	contents, err := ioutil.ReadFile(r.LogPath())
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("read log: %w", err)
 	}
-	return string(commitSha)
-}
 
-func getRepoRoot() string {
-	relPath := getRootRelPath()
-	absPath, err := filepath.Abs(relPath)
-	if err != nil {
-		log.Fatal(err)
+	var entries []LogEntry
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("read log: invalid entry %q", line)
+		}
+		t, err := time.Parse(time.RFC3339, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("read log: invalid entry time %q: %w", line, err)
+		}
+		entries = append(entries, LogEntry{Time: t, Hash: fields[1], ParentHash: fields[2], Message: fields[3]})
 	}
-	return absPath
+	return entries, nil
 }
 
-func getRootRelPath() string {
-	path := "."
-	if isRepoRoot(path) {
-		return path
+// UpdateLog adds a log entry into a LOG file. The whole file is rewritten atomically.
+func (r *Repository) UpdateLog(entry string) error {
+	contents, err := ioutil.ReadFile(r.LogPath())
+	if err != nil {
+		return fmt.Errorf("update log: %w", err)
 	}
 
-	path = ".."
-
-	for {
-		if abs, _ := filepath.Abs(path); abs == string(filepath.Separator) {
-			log.Fatal(ErrNotGotRepo)
-		}
-		if isRepoRoot(path) {
-			return path
-		}
-		path = path + string(filepath.Separator) + ".."
+	if err := writeFileAtomic(r.LogPath(), append(contents, entry...), 0644); err != nil {
+		return fmt.Errorf("update log: %w", err)
 	}
 
-	log.Fatal(ErrNotGotRepo)
-	panic("never reach")
+	return nil
 }
 
-func isRepoRoot(path string) bool {
+func isRepoRoot(path string) (bool, error) {
 	files, err := ioutil.ReadDir(path)
 	if err != nil {
-		log.Fatal(err)
+		return false, err
 	}
 
 	for _, file := range files {
-		if file.Name() == gotPath {
-			return true
+		if file.Name() == gotPath && file.IsDir() {
+			return true, nil
 		}
 	}
 
-	return false
+	return false, nil
 }
@@ -1,12 +1,17 @@
 package got
 
 import (
+	"bytes"
+	"errors"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/shved/got/misc"
 )
@@ -28,7 +33,9 @@ func TestMain(m *testing.M) {
 }
 
 func TestInitRepo(t *testing.T) {
-	InitRepo()
+	if _, err := Init(dummyAppPath); err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
 	entries, err := ioutil.ReadDir(dummyAppPath)
 	if err != nil {
 		t.Fatalf("reading dummy app dir: %v", err)
@@ -49,8 +56,453 @@ func TestInitRepo(t *testing.T) {
 		t.Fatalf("no repo dir found in dummy app path, got %v", names)
 	}
 
-	SetRepoRoot()
-	if AbsRepoRoot != dummyAppPath {
-		t.Fatalf("expected repo root to be %v, got %v", dummyAppPath, AbsRepoRoot)
+	repo, err := Discover(path.Join(dummyAppPath, "app/views"))
+	if err != nil {
+		t.Fatalf("discover repo: %v", err)
+	}
+	if repo.Root != dummyAppPath {
+		t.Fatalf("expected repo root to be %v, got %v", dummyAppPath, repo.Root)
+	}
+
+	if _, err := Init(dummyAppPath); !errors.Is(err, ErrRepoAlreadyInited) {
+		t.Fatalf("expected %v on second init, got %v", ErrRepoAlreadyInited, err)
+	}
+}
+
+func TestSeveralRepos(t *testing.T) {
+	dirs := make([]string, 2)
+	for i := range dirs {
+		dir, err := ioutil.TempDir("", "got")
+		if err != nil {
+			t.Fatalf("create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		dirs[i] = dir
+	}
+
+	repos := make([]*Repository, len(dirs))
+	for i, dir := range dirs {
+		repo, err := Init(dir)
+		if err != nil {
+			t.Fatalf("init repo in %v: %v", dir, err)
+		}
+		repos[i] = repo
+	}
+
+	if err := repos[0].AdvanceHead("1111111111111111111111111111111111111111"); err != nil {
+		t.Fatalf("update head: %v", err)
+	}
+
+	head, err := repos[1].ReadHead()
+	if err != nil {
+		t.Fatalf("read head: %v", err)
+	}
+	if head != string(EmptyCommitRef) {
+		t.Fatalf("expected second repo head to stay %s, got %s", EmptyCommitRef, head)
+	}
+
+	if _, err := Open(filepath.Dir(dirs[0])); !errors.Is(err, ErrNotGotRepo) {
+		t.Fatalf("expected %v, got %v", ErrNotGotRepo, err)
+	}
+}
+
+func TestBranches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, err := Init(dir)
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+
+	branch, err := repo.CurrentBranch()
+	if err != nil || branch != DefaultBranch {
+		t.Fatalf("expected to be on %v, got %v, %v", DefaultBranch, branch, err)
+	}
+
+	first := "1111111111111111111111111111111111111111"
+	if err := repo.AdvanceHead(first); err != nil {
+		t.Fatalf("advance head: %v", err)
+	}
+	if sha, _ := repo.ReadBranch(DefaultBranch); sha != first {
+		t.Fatalf("expected %v to point to %v, got %v", DefaultBranch, first, sha)
+	}
+
+	if err := repo.CreateBranch("feature/x", first); err != nil {
+		t.Fatalf("create branch: %v", err)
+	}
+	if err := repo.CreateBranch("feature/x", first); !errors.Is(err, ErrBranchExists) {
+		t.Fatalf("expected %v, got %v", ErrBranchExists, err)
+	}
+	for _, name := range []string{"bad..name", ".hidden", "feature/.hidden"} {
+		if err := repo.CreateBranch(name, first); !errors.Is(err, ErrInvalidRefName) {
+			t.Fatalf("expected %v for %q, got %v", ErrInvalidRefName, name, err)
+		}
+	}
+
+	branches, err := repo.ListBranches()
+	if err != nil {
+		t.Fatalf("list branches: %v", err)
+	}
+	if len(branches) != 2 || branches[0] != "feature/x" || branches[1] != DefaultBranch {
+		t.Fatalf("expected [feature/x %v], got %v", DefaultBranch, branches)
+	}
+
+	if err := repo.SetHeadBranch("feature/x"); err != nil {
+		t.Fatalf("set head branch: %v", err)
+	}
+	second := "2222222222222222222222222222222222222222"
+	if err := repo.AdvanceHead(second); err != nil {
+		t.Fatalf("advance head: %v", err)
+	}
+	if sha, _ := repo.ReadBranch(DefaultBranch); sha != first {
+		t.Fatalf("expected %v to stay on %v, got %v", DefaultBranch, first, sha)
+	}
+
+	if err := repo.DeleteBranch("feature/x"); !errors.Is(err, ErrBranchCheckedOut) {
+		t.Fatalf("expected %v, got %v", ErrBranchCheckedOut, err)
+	}
+
+	if err := repo.DetachHead(first); err != nil {
+		t.Fatalf("detach head: %v", err)
+	}
+	if branch, _ := repo.CurrentBranch(); branch != "" {
+		t.Fatalf("expected detached head, got branch %v", branch)
+	}
+	if err := repo.DeleteBranch("feature/x"); err != nil {
+		t.Fatalf("delete branch: %v", err)
+	}
+	if repo.IsBranch("feature/x") {
+		t.Fatal("expected branch to be deleted")
+	}
+}
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, err := Init(dir)
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+
+	err = repo.WithLock(func() error {
+		if err := repo.Lock(); !errors.Is(err, ErrRepoLocked) {
+			t.Fatalf("expected %v, got %v", ErrRepoLocked, err)
+		}
+		return repo.AdvanceHead("1111111111111111111111111111111111111111")
+	})
+	if err != nil {
+		t.Fatalf("run with lock: %v", err)
+	}
+
+	if _, err := os.Stat(repo.LockPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected lock to be released, got %v", err)
+	}
+
+	// atomic writes leave no temp files behind
+	entries, err := ioutil.ReadDir(filepath.Join(dir, ".got", "refs", "heads"))
+	if err != nil {
+		t.Fatalf("read refs dir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != DefaultBranch {
+		var names []string
+		for _, fi := range entries {
+			names = append(names, fi.Name())
+		}
+		t.Fatalf("expected only %v ref, got %v", DefaultBranch, names)
+	}
+}
+
+func TestRepoFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := InitWithHash(dir, SHA256); err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatalf("open repo: %v", err)
+	}
+	if repo.Hash.Name != SHA256.Name {
+		t.Fatalf("expected %s object format, got %s", SHA256.Name, repo.Hash.Name)
+	}
+	head, err := repo.ReadHead()
+	if err != nil || head != strings.Repeat("0", 64) {
+		t.Fatalf("expected a 64 chars empty ref, got %q, %v", head, err)
+	}
+	if _, err := repo.ReadRef("0000000000000000000000000000000000000001"); !errors.Is(err, ErrUnknownRevision) {
+		t.Fatalf("expected %v for a sha1 hash, got %v", ErrUnknownRevision, err)
+	}
+
+	configs := map[string]string{
+		"newer version":     "[core]\n\trepositoryformatversion = 2\n",
+		"unknown extension": "[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectformat = sha256\n\tworktreeconfig = true\n",
+		"unknown format":    "[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectformat = md5\n",
+		"undeclared codec":  "[core]\n\trepositoryformatversion = 0\n\tcompression = zstd:3\n",
+	}
+	for name, config := range configs {
+		if err := ioutil.WriteFile(repo.ConfigPath(), []byte(config), 0644); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+		if _, err := Open(dir); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Fatalf("%s: expected %v, got %v", name, ErrUnsupportedFormat, err)
+		}
+	}
+
+	// repos made before the format was recorded are sha1 ones
+	if err := os.Remove(repo.ConfigPath()); err != nil {
+		t.Fatalf("remove config: %v", err)
+	}
+	if repo, err = Discover(dir); err != nil || repo.Hash.Name != SHA1.Name {
+		t.Fatalf("expected %s repo without config, got %v", SHA1.Name, err)
+	}
+
+	zstd := Compression{Codec: CodecZstd, Level: 19}
+	if err := repo.SetCompression(zstd); err != nil {
+		t.Fatalf("set compression: %v", err)
+	}
+	if repo, err = Open(dir); err != nil || repo.Compression != zstd || repo.Hash.Name != SHA1.Name {
+		t.Fatalf("expected %s compression to be recorded, got %v, %v", zstd, repo.Compression, err)
+	}
+
+	// objects written with older codecs are kept, so their codecs stay declared
+	for _, c := range []Compression{{Codec: CodecNone}, DefaultCompression} {
+		if err := repo.SetCompression(c); err != nil {
+			t.Fatalf("set compression: %v", err)
+		}
+	}
+	if repo, err = Open(dir); err != nil || repo.Compression != DefaultCompression {
+		t.Fatalf("expected %s compression to be recorded, got %v, %v", DefaultCompression, repo.Compression, err)
+	}
+	config, err := ReadConfigFile(repo.ConfigPath())
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if codecs, _ := config.Get("extensions.compression"); codecs != "zstd,none" {
+		t.Fatalf("expected earlier codecs to stay declared, got %q", codecs)
+	}
+	if err := repo.ForgetOtherCodecs(); err != nil {
+		t.Fatalf("forget codecs: %v", err)
+	}
+	if config, err = ReadConfigFile(repo.ConfigPath()); err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if codecs, ok := config.Get("extensions.compression"); ok {
+		t.Fatalf("expected no codecs declared after recompression, got %q", codecs)
+	}
+}
+
+func TestObjectHeader(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.FixedZone("", 3*60*60))
+	h := ObjectHeader{
+		Type:      "commit",
+		Parents:   []string{"1111111111111111111111111111111111111111"},
+		Author:    Signature{Name: "Jörg Müller", Email: "jorg@example.com", When: when},
+		Committer: Signature{Name: "Ann", Email: "ann@example.com", When: when.UTC()},
+		Message:   "первый коммит\n\ndetails",
+	}
+	body := []byte("blob\t2222222222222222222222222222222222222222\tmain.go")
+
+	data, err := EncodeObject(h, body)
+	if err != nil {
+		t.Fatalf("encode object: %v", err)
+	}
+	res, resBody, err := DecodeObject(data)
+	if err != nil {
+		t.Fatalf("decode object: %v", err)
+	}
+	h.Size = len(body)
+	if !reflect.DeepEqual(res, h) || !bytes.Equal(resBody, body) {
+		t.Fatalf("expected header %+v to survive encoding, got %+v", h, res)
+	}
+	if _, offset := res.Author.When.Zone(); offset != 3*60*60 || res.Author.When.Nanosecond() != 123456789 {
+		t.Fatalf("expected author time zone and nanoseconds to be kept, got %v", res.Author.When)
+	}
+
+	if _, _, err := DecodeObject(data[:len(data)-1]); !errors.Is(err, ErrRepoCorrupt) {
+		t.Fatalf("expected %v for a truncated body, got %v", ErrRepoCorrupt, err)
+	}
+	if _, err := EncodeObject(ObjectHeader{Type: "tag", Tagger: Signature{Name: "a <b>"}}, nil); err == nil {
+		t.Fatal("expected a signature with angle brackets to be refused")
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, env := range []string{"HOME", EnvAuthorName, EnvAuthorEmail, EnvCommitterName, EnvCommitterEmail} {
+		if old, ok := os.LookupEnv(env); ok {
+			defer os.Setenv(env, old)
+		} else {
+			defer os.Unsetenv(env)
+		}
+		os.Unsetenv(env)
+	}
+	os.Setenv("HOME", dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "repo"), 0755); err != nil {
+		t.Fatalf("create repo dir: %v", err)
+	}
+	repo, err := Init(filepath.Join(dir, "repo"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	when := time.Now()
+
+	userConfig := "[user]\n\tname = Ann\n\temail = ann@example.com\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, ".gotconfig"), []byte(userConfig), 0644); err != nil {
+		t.Fatalf("write user config: %v", err)
+	}
+	if sig, err := repo.Author(when); err != nil || sig.Name != "Ann" || sig.Email != "ann@example.com" || !sig.When.Equal(when) {
+		t.Fatalf("expected author from the user config, got %v, %v", sig, err)
+	}
+
+	repoConfig, err := ioutil.ReadFile(repo.ConfigPath())
+	if err != nil {
+		t.Fatalf("read repo config: %v", err)
+	}
+	repoConfig = append(repoConfig, "[user]\n\temail = ann@work.example.com\n"...)
+	if err := ioutil.WriteFile(repo.ConfigPath(), repoConfig, 0644); err != nil {
+		t.Fatalf("write repo config: %v", err)
+	}
+	if sig, err := repo.Committer(when); err != nil || sig.Name != "Ann" || sig.Email != "ann@work.example.com" {
+		t.Fatalf("expected repo config email to override the user one, got %v, %v", sig, err)
+	}
+
+	os.Setenv(EnvAuthorName, "Bob")
+	if sig, _ := repo.Author(when); sig.Name != "Bob" || sig.Email != "ann@work.example.com" {
+		t.Fatalf("expected author name from %s, got %v", EnvAuthorName, sig)
+	}
+	if sig, _ := repo.Committer(when); sig.Name != "Ann" {
+		t.Fatalf("expected %s to leave the committer alone, got %v", EnvAuthorName, sig)
+	}
+
+	os.Setenv(EnvAuthorName, "Bob <bob>")
+	if _, err := repo.Author(when); err == nil {
+		t.Fatal("expected a name with angle brackets to be refused")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if old, ok := os.LookupEnv("HOME"); ok {
+		defer os.Setenv("HOME", old)
+	}
+	os.Setenv("HOME", dir)
+	defer func(p string) { SystemConfigPath = p }(SystemConfigPath)
+	SystemConfigPath = filepath.Join(dir, "gotconfig")
+
+	if err := os.Mkdir(filepath.Join(dir, "repo"), 0755); err != nil {
+		t.Fatalf("create repo dir: %v", err)
+	}
+	repo, err := Init(filepath.Join(dir, "repo"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+
+	files := map[string]string{
+		SystemConfigPath:             "[core]\n\tworkers = 2 # two cores\n[gc]\n\tgrace = 720h ; a month\n\tauto # on\n",
+		UserConfigPath():             "# user settings\n[core]\n\tworkers = 4\n\texcludesfile = ~/ignore\n\tcompression = zstd\n[user]\n\tname = \"Ann \\\"A\\\" Smith #1\" # full name\n",
+		filepath.Join(dir, "ignore"): "# editor files\n*.swp\n",
+	}
+	for p, contents := range files {
+		if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+	}
+
+	repoConfig, err := ReadConfigFile(repo.ConfigPath())
+	if err != nil {
+		t.Fatalf("read repo config: %v", err)
+	}
+	if err := repoConfig.Set("core.workers", "8"); err != nil {
+		t.Fatalf("set config value: %v", err)
+	}
+	if err := repoConfig.Set("user.email", " spaced "); err != nil {
+		t.Fatalf("set config value: %v", err)
+	}
+	if err := repoConfig.Set("bad name", "x"); !errors.Is(err, ErrInvalidConfigName) {
+		t.Fatalf("expected %v, got %v", ErrInvalidConfigName, err)
+	}
+	if err := repoConfig.Write(); err != nil {
+		t.Fatalf("write repo config: %v", err)
+	}
+
+	config, err := repo.Config()
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if workers, err := config.Int("core.workers", 0); err != nil || workers != 8 {
+		t.Fatalf("expected the repo config to win, got %d, %v", workers, err)
+	}
+	if grace, err := config.Duration("gc.grace", 0); err != nil || grace != 720*time.Hour {
+		t.Fatalf("expected the system config grace, got %v, %v", grace, err)
+	}
+	if auto, err := config.Bool("gc.auto", false); err != nil || !auto {
+		t.Fatalf("expected a key without a value to be true, got %v, %v", auto, err)
+	}
+	if name := config.String("User.Name", ""); name != `Ann "A" Smith #1` {
+		t.Fatalf("expected a quoted user name to be unquoted without the comment, got %q", name)
+	}
+	if email := config.String("user.email", ""); email != " spaced " {
+		t.Fatalf("expected spaces to survive writing, got %q", email)
+	}
+	if _, err := config.Bool("core.workers", false); !errors.Is(err, ErrInvalidConfigValue) {
+		t.Fatalf("expected %v for a number read as a boolean, got %v", ErrInvalidConfigValue, err)
+	}
+	// format values are read from the repo config only, as the repo is opened with them
+	if v, ok := config.Get("core.compression"); ok && v == "zstd" {
+		t.Fatal("expected user config compression to be ignored")
+	}
+	for _, e := range config.List() {
+		if e.Scope != ScopeRepo && IsFormatConfig(e.Name) {
+			t.Fatalf("expected format values to be listed from the repo config only, got %v", e)
+		}
+	}
+	if scopes := config.List(); scopes[0].Scope != ScopeSystem || scopes[len(scopes)-1].Scope != ScopeRepo {
+		t.Fatalf("expected config list to go from system to repo values, got %v", scopes)
+	}
+
+	if repo, err = Open(repo.Root); err != nil || repo.Workers != 8 {
+		t.Fatalf("expected core.workers to set repo workers, got %v", err)
+	}
+	entries, err := repo.IgnoreEntries()
+	if err != nil || entries[len(entries)-1] != "*.swp" {
+		t.Fatalf("expected excludes file patterns to be ignored, got %v, %v", entries, err)
+	}
+
+	// format changes keep other values and comments
+	if err := repo.SetCompression(Compression{Codec: CodecGzip, Level: 9}); err != nil {
+		t.Fatalf("set compression: %v", err)
+	}
+	if repoConfig, err = ReadConfigFile(repo.ConfigPath()); err != nil {
+		t.Fatalf("read repo config: %v", err)
+	}
+	if v, _ := repoConfig.Get("core.workers"); v != "8" {
+		t.Fatalf("expected format change to keep other values, got %v", repoConfig.List())
+	}
+	if found, err := repoConfig.Unset("user.email"); err != nil || !found {
+		t.Fatalf("expected user.email to be unset, got %v, %v", found, err)
+	}
+	if !IsFormatConfig("extensions.objectformat") || !IsFormatConfig("core.compression") || IsFormatConfig("core.workers") {
+		t.Fatal("expected format values to be told apart from settings")
 	}
 }
@@ -0,0 +1,60 @@
+package got
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// HashAlgorithm is an algorithm repo objects are named by.
+type HashAlgorithm struct {
+	// Name is the algorithm name recorded in the repo config.
+	Name string
+	// Size is a hash sum size in bytes.
+	Size int
+
+	new func() hash.Hash
+}
+
+var (
+	// SHA1 is the hash algorithm of repos made before the object format was configurable.
+	SHA1 = HashAlgorithm{Name: "sha1", Size: sha1.Size, new: sha1.New}
+	// SHA256 is the hash algorithm of repos inited with the sha256 object format.
+	SHA256 = HashAlgorithm{Name: "sha256", Size: sha256.Size, new: sha256.New}
+)
+
+// HashAlgorithmByName returns a hash algorithm by its config name.
+func HashAlgorithmByName(name string) (HashAlgorithm, error) {
+	switch strings.ToLower(name) {
+	case SHA1.Name:
+		return SHA1, nil
+	case SHA256.Name:
+		return SHA256, nil
+	default:
+		return HashAlgorithm{}, fmt.Errorf("%w: unknown object format %q", ErrUnsupportedFormat, name)
+	}
+}
+
+// Sum returns a data hash sum.
+func (a HashAlgorithm) Sum(data []byte) []byte {
+	h := a.new()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// EmptyRef returns a zero hash a branch without commits resolves into.
+func (a HashAlgorithm) EmptyRef() string {
+	return strings.Repeat("0", 2*a.Size)
+}
+
+// IsHash tells whether a string is a hex encoded hash sum of the algorithm.
+func (a HashAlgorithm) IsHash(s string) bool {
+	if len(s) != 2*a.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
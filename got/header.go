@@ -0,0 +1,191 @@
+package got
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ObjectHeader is object metadata written in front of the object body. Headers are
+// "key value" lines followed by an empty line, unknown keys are skipped on reading, so newer
+// got versions could add headers. Tree, blob and tag names are hashes of the body only, so their
+// headers could be rewritten without renaming them. Commit names are hashes of the whole payload,
+// so commits of the same tree made by different people or with different messages never share a name.
+type ObjectHeader struct {
+	Type string
+	// Size is the body size in bytes, it is set by EncodeObject.
+	Size int
+	// Name is a tag name, or a name a tree or a blob was first stored under.
+	Name      string
+	Parents   []string
+	Author    Signature
+	Committer Signature
+	Tagger    Signature
+	// Time is when a tree or a blob was first stored.
+	Time    time.Time
+	Message string
+}
+
+// Signature is a person along with the time they made a change at. The time keeps its
+// time zone and sub-second precision.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+var errInvalidSignature = errors.New("invalid signature")
+
+// IsZero reports whether a signature is empty.
+func (s Signature) IsZero() bool {
+	return s.Name == "" && s.Email == "" && s.When.IsZero()
+}
+
+// String returns a signature in the "Name <email> time" form.
+func (s Signature) String() string {
+	sig := s.Name + " <" + s.Email + ">"
+	if !s.When.IsZero() {
+		sig += " " + s.When.Format(time.RFC3339Nano)
+	}
+	return strings.TrimSpace(sig)
+}
+
+// validate checks that a signature could be parsed back.
+func (s Signature) validate() error {
+	if strings.ContainsAny(s.Name, "<>\n") || strings.ContainsAny(s.Email, "<>\n") {
+		return fmt.Errorf("%w %q: name and email could not contain angle brackets or new lines", errInvalidSignature, s.Name+" <"+s.Email+">")
+	}
+	return nil
+}
+
+// DefaultSignature returns a signature of the current OS user at the local host made
+// at a given time.
+func DefaultSignature(when time.Time) Signature {
+	sig := Signature{Name: "unknown", When: when}
+	if u, err := user.Current(); err == nil {
+		sig.Name = u.Username
+		if u.Name != "" {
+			sig.Name = u.Name
+		}
+		sig.Email = u.Username
+	}
+	if host, err := os.Hostname(); err == nil && sig.Email != "" {
+		sig.Email += "@" + host
+	}
+	// OS user names could hold anything, the signature has to be parsed back
+	strip := strings.NewReplacer("<", "", ">", "", "\n", "")
+	sig.Name, sig.Email = strip.Replace(sig.Name), strip.Replace(sig.Email)
+	return sig
+}
+
+// ParseSignature parses a signature in the "Name <email> time" form, the time is optional.
+func ParseSignature(s string) (Signature, error) {
+	open, close := strings.IndexByte(s, '<'), strings.IndexByte(s, '>')
+	if open < 0 || close < open {
+		return Signature{}, fmt.Errorf("%w %q", errInvalidSignature, s)
+	}
+	sig := Signature{Name: strings.TrimSpace(s[:open]), Email: s[open+1 : close]}
+	if t := strings.TrimSpace(s[close+1:]); t != "" {
+		when, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return Signature{}, fmt.Errorf("%w %q: %v", errInvalidSignature, s, err)
+		}
+		sig.When = when
+	}
+	return sig, nil
+}
+
+// EncodeObject returns an object payload made of a header and a body.
+func EncodeObject(h ObjectHeader, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "type %s\nsize %d\n", h.Type, len(body))
+	if h.Name != "" {
+		fmt.Fprintf(&buf, "name %s\n", strconv.Quote(h.Name))
+	}
+	for _, p := range h.Parents {
+		fmt.Fprintf(&buf, "parent %s\n", p)
+	}
+	for _, f := range []struct {
+		key string
+		sig Signature
+	}{{"author", h.Author}, {"committer", h.Committer}, {"tagger", h.Tagger}} {
+		if f.sig.IsZero() {
+			continue
+		}
+		if err := f.sig.validate(); err != nil {
+			return nil, fmt.Errorf("encode %s: %w", h.Type, err)
+		}
+		fmt.Fprintf(&buf, "%s %s\n", f.key, f.sig)
+	}
+	if !h.Time.IsZero() {
+		fmt.Fprintf(&buf, "time %s\n", h.Time.Format(time.RFC3339Nano))
+	}
+	if h.Message != "" {
+		fmt.Fprintf(&buf, "message %s\n", strconv.Quote(h.Message))
+	}
+	buf.WriteByte('\n')
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// DecodeObject splits an object payload into a header and a body. Malformed payloads
+// return an error wrapping ErrRepoCorrupt.
+func DecodeObject(data []byte) (ObjectHeader, []byte, error) {
+	var h ObjectHeader
+	sizeSeen := false
+	rest := data
+	for {
+		nl := bytes.IndexByte(rest, '\n')
+		if nl < 0 {
+			return ObjectHeader{}, nil, fmt.Errorf("decode object: no header end: %w", ErrRepoCorrupt)
+		}
+		line := string(rest[:nl])
+		rest = rest[nl+1:]
+		if line == "" {
+			break
+		}
+
+		key, value := line, ""
+		if sp := strings.IndexByte(line, ' '); sp >= 0 {
+			key, value = line[:sp], line[sp+1:]
+		}
+		var err error
+		switch key {
+		case "type":
+			h.Type = value
+		case "size":
+			h.Size, err = strconv.Atoi(value)
+			sizeSeen = true
+		case "name":
+			h.Name, err = strconv.Unquote(value)
+		case "parent":
+			h.Parents = append(h.Parents, value)
+		case "author":
+			h.Author, err = ParseSignature(value)
+		case "committer":
+			h.Committer, err = ParseSignature(value)
+		case "tagger":
+			h.Tagger, err = ParseSignature(value)
+		case "time":
+			h.Time, err = time.Parse(time.RFC3339Nano, value)
+		case "message":
+			h.Message, err = strconv.Unquote(value)
+		}
+		if err != nil {
+			return ObjectHeader{}, nil, fmt.Errorf("decode object: invalid %s header %q: %w", key, value, ErrRepoCorrupt)
+		}
+	}
+
+	if h.Type == "" || !sizeSeen {
+		return ObjectHeader{}, nil, fmt.Errorf("decode object: no type or size header: %w", ErrRepoCorrupt)
+	}
+	if h.Size != len(rest) {
+		return ObjectHeader{}, nil, fmt.Errorf("decode object: body is %d bytes, header says %d: %w", len(rest), h.Size, ErrRepoCorrupt)
+	}
+	return h, rest, nil
+}
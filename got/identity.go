@@ -0,0 +1,51 @@
+package got
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Environment variables overriding the identity commits are made with.
+const (
+	EnvAuthorName     = "GOT_AUTHOR_NAME"
+	EnvAuthorEmail    = "GOT_AUTHOR_EMAIL"
+	EnvCommitterName  = "GOT_COMMITTER_NAME"
+	EnvCommitterEmail = "GOT_COMMITTER_EMAIL"
+)
+
+// Author returns a signature commits are authored with at a given time. Name and email are taken
+// from GOT_AUTHOR_NAME and GOT_AUTHOR_EMAIL, then from user.name and user.email config values,
+// falling back to the OS user.
+func (r *Repository) Author(when time.Time) (Signature, error) {
+	return r.identity(EnvAuthorName, EnvAuthorEmail, when)
+}
+
+// Committer returns a signature commits are committed with at a given time. It is looked up
+// the same way Author is, with GOT_COMMITTER_NAME and GOT_COMMITTER_EMAIL variables.
+func (r *Repository) Committer(when time.Time) (Signature, error) {
+	return r.identity(EnvCommitterName, EnvCommitterEmail, when)
+}
+
+// identity looks up a signature in given environment variables and the config.
+func (r *Repository) identity(nameEnv, emailEnv string, when time.Time) (Signature, error) {
+	config, err := r.Config()
+	if err != nil {
+		return Signature{}, fmt.Errorf("read identity: %w", err)
+	}
+	sig := DefaultSignature(when)
+	sig.Name = config.String("user.name", sig.Name)
+	sig.Email = config.String("user.email", sig.Email)
+
+	if name, ok := os.LookupEnv(nameEnv); ok {
+		sig.Name = name
+	}
+	if email, ok := os.LookupEnv(emailEnv); ok {
+		sig.Email = email
+	}
+
+	if err := sig.validate(); err != nil {
+		return Signature{}, fmt.Errorf("read identity: %w", err)
+	}
+	return sig, nil
+}
@@ -0,0 +1,138 @@
+package got
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var indexPath string = path.Join(gotPath, "index")
+
+// IndexEntry is a staged file state. Size and modification time are kept to tell whether a worktree
+// file has changed since it was staged.
+type IndexEntry struct {
+	Path    string
+	Mode    os.FileMode
+	Size    int64
+	ModTime time.Time
+	Hash    string
+}
+
+// Index is a staging area holding file states the next commit is made of.
+type Index struct {
+	entries map[string]IndexEntry
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{entries: make(map[string]IndexEntry)}
+}
+
+// Add stages an entry replacing any entry with the same path.
+func (idx *Index) Add(e IndexEntry) {
+	idx.entries[e.Path] = e
+}
+
+// Remove unstages a path.
+func (idx *Index) Remove(p string) {
+	delete(idx.entries, p)
+}
+
+// Entry returns a staged entry by path.
+func (idx *Index) Entry(p string) (IndexEntry, bool) {
+	e, ok := idx.entries[p]
+	return e, ok
+}
+
+// Entries returns all the staged entries sorted by path.
+func (idx *Index) Entries() []IndexEntry {
+	entries := make([]IndexEntry, 0, len(idx.entries))
+	for _, e := range idx.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// IndexPath returns absolute index file path.
+func (r *Repository) IndexPath() string {
+	return r.path(indexPath)
+}
+
+// ReadIndex reads the index file. An error wrapping os.ErrNotExist is returned when a repo has no index yet.
+func (r *Repository) ReadIndex() (*Index, error) {
+	contents, err := ioutil.ReadFile(r.IndexPath())
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+
+	idx := NewIndex()
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		e, err := parseIndexEntry(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("read index: %w", err)
+		}
+		idx.Add(e)
+	}
+
+	return idx, scanner.Err()
+}
+
+// WriteIndex writes the index file. Every entry is a line of tab separated mode, size,
+// modification time, blob hash and path.
+func (r *Repository) WriteIndex(idx *Index) error {
+	var buf bytes.Buffer
+	for _, e := range idx.Entries() {
+		var mtime int64
+		if !e.ModTime.IsZero() {
+			mtime = e.ModTime.UnixNano()
+		}
+		fmt.Fprintf(&buf, "%o\t%d\t%d\t%s\t%s\n", uint32(e.Mode), e.Size, mtime, e.Hash, filepath.ToSlash(e.Path))
+	}
+
+	if err := writeFileAtomic(r.IndexPath(), buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write index: %w", err)
+	}
+	return nil
+}
+
+// parseIndexEntry parses an index file line.
+func parseIndexEntry(line string) (IndexEntry, error) {
+	fields := strings.SplitN(line, "\t", 5)
+	if len(fields) != 5 {
+		return IndexEntry{}, fmt.Errorf("invalid index entry %q", line)
+	}
+
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return IndexEntry{}, fmt.Errorf("invalid index entry mode %q: %w", line, err)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return IndexEntry{}, fmt.Errorf("invalid index entry size %q: %w", line, err)
+	}
+	mtime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return IndexEntry{}, fmt.Errorf("invalid index entry time %q: %w", line, err)
+	}
+
+	e := IndexEntry{
+		Mode: os.FileMode(mode),
+		Size: size,
+		Hash: fields[3],
+		Path: filepath.FromSlash(fields[4]),
+	}
+	if mtime != 0 {
+		e.ModTime = time.Unix(0, mtime)
+	}
+	return e, nil
+}
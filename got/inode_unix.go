@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package got
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns a file inode number, so a file replaced by another one with the same size
+// and modification time is told apart.
+func fileInode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,8 @@
+package got
+
+import "os"
+
+// fileInode returns zero since file info carries no inode numbers on windows.
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
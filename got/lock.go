@@ -0,0 +1,90 @@
+package got
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+var lockPath string = path.Join(gotPath, "lock")
+
+// LockPath returns absolute lock file path.
+func (r *Repository) LockPath() string {
+	return r.path(lockPath)
+}
+
+// Lock creates a lock file preventing other got processes from mutating the repo. An error wrapping
+// ErrRepoLocked is returned when the lock is held by someone else.
+func (r *Repository) Lock() error {
+	f, err := os.OpenFile(r.LockPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%w: %s exists, another got process is running or has crashed, remove the file if you are sure no got process is running", ErrRepoLocked, r.LockPath())
+	}
+	if err != nil {
+		return fmt.Errorf("lock repo: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(strconv.Itoa(os.Getpid()) + "\n"); err != nil {
+		return fmt.Errorf("lock repo: %w", err)
+	}
+	return nil
+}
+
+// Unlock removes the lock file.
+func (r *Repository) Unlock() error {
+	if err := os.Remove(r.LockPath()); err != nil {
+		return fmt.Errorf("unlock repo: %w", err)
+	}
+	return nil
+}
+
+// WithLock runs fn holding the repo lock.
+func (r *Repository) WithLock(fn func() error) (err error) {
+	if err := r.Lock(); err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := r.Unlock(); err == nil {
+			err = unlockErr
+		}
+	}()
+	return fn()
+}
+
+// writeFileAtomic writes data into a temp file in the target dir, syncs it and renames it over
+// the target, so readers see either old or new contents but never a truncated file.
+func writeFileAtomic(p string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, p); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
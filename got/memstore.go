@@ -0,0 +1,127 @@
+package got
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is an object store keeping objects in memory. It is safe for concurrent use
+// and intended mostly for tests.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]map[string]RawObject
+	staged  map[string]map[string]RawObject
+}
+
+// NewMemoryStore returns an empty in-memory object store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: make(map[string]map[string]RawObject)}
+}
+
+// Get returns a copy of a stored object.
+func (s *MemoryStore) Get(objType, hash string) (*RawObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.objects[objType][hash]
+	if !ok {
+		return nil, fmt.Errorf("get %s %s: %w", objType, hash, ErrObjDoesNotExist)
+	}
+	obj.Data = append([]byte(nil), obj.Data...)
+	return &obj, nil
+}
+
+// Put stores a copy of an object.
+func (s *MemoryStore) Put(objType, hash string, obj *RawObject) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.objects[objType] == nil {
+		s.objects[objType] = make(map[string]RawObject)
+	}
+	stored := *obj
+	stored.Data = append([]byte(nil), obj.Data...)
+	s.objects[objType][hash] = stored
+	return nil
+}
+
+// Has reports whether an object is stored.
+func (s *MemoryStore) Has(objType, hash string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.objects[objType][hash]
+	return ok, nil
+}
+
+// Delete removes a stored object.
+func (s *MemoryStore) Delete(objType, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.objects[objType], hash)
+	return nil
+}
+
+// Iterate calls fn for stored objects of a given type in hash order.
+func (s *MemoryStore) Iterate(objType string, fn func(hash string) error) error {
+	s.mu.RLock()
+	hashes := make([]string, 0, len(s.objects[objType]))
+	for hash := range s.objects[objType] {
+		hashes = append(hashes, hash)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		if err := fn(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StageRewrite passes every stored object through fn and keeps the results aside until they
+// are published.
+func (s *MemoryStore) StageRewrite(fn func(objType, hash string, obj *RawObject) (*RawObject, error)) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	staged := make(map[string]map[string]RawObject)
+	var n int
+	for objType, objects := range s.objects {
+		for hash, obj := range objects {
+			obj.Data = append([]byte(nil), obj.Data...)
+			rewritten, err := fn(objType, hash, &obj)
+			if err != nil {
+				return 0, fmt.Errorf("stage rewrite: %w", err)
+			}
+			if staged[objType] == nil {
+				staged[objType] = make(map[string]RawObject)
+			}
+			staged[objType][hash] = *rewritten
+			n++
+		}
+	}
+	s.staged = staged
+	return n, nil
+}
+
+// Staged reports whether there are staged objects.
+func (s *MemoryStore) Staged() (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.staged != nil, nil
+}
+
+// PublishStaged replaces stored objects with the staged ones.
+func (s *MemoryStore) PublishStaged() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.staged != nil {
+		s.objects, s.staged = s.staged, nil
+	}
+	return nil
+}
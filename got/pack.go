@@ -0,0 +1,196 @@
+package got
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	packDir       = "pack"
+	packHeader    = "GOTPACK 1\n"
+	packIdxHeader = "GOTIDX 2\n"
+	// packIdxHeaderV1 is a header of indexes written before delta encoding was introduced
+	packIdxHeaderV1 = "GOTIDX 1\n"
+	// stagedDir keeps a pack written by a rewrite until it is published
+	stagedDir = "staged"
+)
+
+// packEntry is a location of an object archive inside a pack data file. Delta encoded objects
+// have a base object hash, their archive holds delta instructions instead of the object content.
+type packEntry struct {
+	objType string
+	hash    string
+	offset  int64
+	length  int64
+	base    string
+}
+
+// pack is a pack data file along with its index. Pack data is object archives written one
+// after another, the index keeps their locations sorted by object type and hash.
+type pack struct {
+	name     string
+	dataPath string
+	idxPath  string
+	entries  []packEntry
+}
+
+// find looks an object up in the pack index.
+func (p *pack) find(objType, hash string) (packEntry, bool) {
+	i := sort.Search(len(p.entries), func(i int) bool {
+		e := p.entries[i]
+		return e.objType > objType || e.objType == objType && e.hash >= hash
+	})
+	if i < len(p.entries) && p.entries[i].objType == objType && p.entries[i].hash == hash {
+		return p.entries[i], true
+	}
+	return packEntry{}, false
+}
+
+// read returns a packed object archive.
+func (p *pack) read(e packEntry) ([]byte, error) {
+	f, err := os.Open(p.dataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, e.length)
+	if _, err := f.ReadAt(data, e.offset); err != nil {
+		return nil, fmt.Errorf("read %s %s from pack %s: %w", e.objType, e.hash, p.name, err)
+	}
+	return data, nil
+}
+
+// loadPacks reads indexes of all the packs in a dir. Packs without an index are still being
+// written and are skipped.
+func loadPacks(dir string) ([]*pack, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load packs: %w", err)
+	}
+
+	var packs []*pack
+	for _, fi := range entries {
+		name := fi.Name()
+		if strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".idx") {
+			continue
+		}
+		p, err := loadPack(filepath.Join(dir, strings.TrimSuffix(name, ".idx")))
+		if err != nil {
+			return nil, err
+		}
+		packs = append(packs, p)
+	}
+	return packs, nil
+}
+
+// loadPack reads a pack index. Every index line is a tab separated object type, hash, offset
+// and length of the object archive in the pack data file, optionally followed by a delta base hash.
+func loadPack(base string) (*pack, error) {
+	p := &pack{name: filepath.Base(base), dataPath: base + ".pack", idxPath: base + ".idx"}
+
+	contents, err := ioutil.ReadFile(p.idxPath)
+	if err != nil {
+		return nil, fmt.Errorf("load pack %s: %w", p.name, err)
+	}
+	if !bytes.HasPrefix(contents, []byte(packIdxHeader)) && !bytes.HasPrefix(contents, []byte(packIdxHeaderV1)) {
+		return nil, fmt.Errorf("load pack %s: unknown index format: %w", p.name, ErrRepoCorrupt)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents[bytes.IndexByte(contents, '\n')+1:]))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 4 && len(fields) != 5 {
+			return nil, fmt.Errorf("load pack %s: invalid entry %q: %w", p.name, scanner.Text(), ErrRepoCorrupt)
+		}
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("load pack %s: invalid entry offset %q: %w", p.name, scanner.Text(), ErrRepoCorrupt)
+		}
+		length, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("load pack %s: invalid entry length %q: %w", p.name, scanner.Text(), ErrRepoCorrupt)
+		}
+		e := packEntry{objType: fields[0], hash: fields[1], offset: offset, length: length}
+		if len(fields) == 5 {
+			e.base = fields[4]
+		}
+		p.entries = append(p.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("load pack %s: %w", p.name, err)
+	}
+
+	sort.Slice(p.entries, func(i, j int) bool { return entryLess(p.entries[i], p.entries[j]) })
+	return p, nil
+}
+
+// writePack writes object archives into a new pack named after its data sha1 and returns the pack.
+// The index is written last, so a pack is never seen before it is complete.
+func writePack(dir string, objs []*packObject) (*pack, error) {
+	objs = append([]*packObject(nil), objs...)
+	sort.Slice(objs, func(i, j int) bool {
+		return entryLess(packEntry{objType: objs[i].objType, hash: objs[i].hash}, packEntry{objType: objs[j].objType, hash: objs[j].hash})
+	})
+
+	var data bytes.Buffer
+	data.WriteString(packHeader)
+	var idx bytes.Buffer
+	idx.WriteString(packIdxHeader)
+	entries := make([]packEntry, 0, len(objs))
+	for _, o := range objs {
+		e := packEntry{objType: o.objType, hash: o.hash, offset: int64(data.Len()), length: int64(len(o.archive))}
+		data.Write(o.archive)
+		fmt.Fprintf(&idx, "%s\t%s\t%d\t%d", e.objType, e.hash, e.offset, e.length)
+		if o.base != nil {
+			e.base = o.base.hash
+			fmt.Fprintf(&idx, "\t%s", e.base)
+		}
+		idx.WriteString("\n")
+		entries = append(entries, e)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("pack-%x", sha1.Sum(data.Bytes()))
+	base := filepath.Join(dir, name)
+	p := &pack{name: name, dataPath: base + ".pack", idxPath: base + ".idx", entries: entries}
+	if err := writeFileAtomic(p.dataPath, data.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(p.idxPath, idx.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// remove deletes pack files, the index goes first so the pack is not read anymore.
+func (p *pack) remove() error {
+	if err := os.Remove(p.idxPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(p.dataPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// entryLess orders pack entries by object type and hash.
+func entryLess(a, b packEntry) bool {
+	if a.objType != b.objType {
+		return a.objType < b.objType
+	}
+	return a.hash < b.hash
+}
@@ -0,0 +1,329 @@
+package got
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultBranch is a branch HEAD points to in a freshly initialized repo.
+const DefaultBranch = "master"
+
+// symRefPrefix starts HEAD contents when HEAD is a symbolic ref pointing to a branch.
+const symRefPrefix = "ref: "
+
+// branchRefPrefix starts symbolic refs pointing to branches.
+const branchRefPrefix = "refs/heads/"
+
+// ReadHead reads commit hash HEAD points to. HEAD is either a symbolic ref to a branch
+// or a detached commit hash. A branch without commits resolves into EmptyRef.
+func (r *Repository) ReadHead() (string, error) {
+	branch, err := r.CurrentBranch()
+	if err != nil {
+		return "", err
+	}
+
+	if branch == "" {
+		head, err := r.readHeadFile()
+		if err != nil {
+			return "", err
+		}
+		return r.parseHash(head)
+	}
+
+	commitSha, err := r.ReadBranch(branch)
+	if os.IsNotExist(err) {
+		return r.EmptyRef(), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read head: %w", err)
+	}
+	return commitSha, nil
+}
+
+// AdvanceHead moves the current branch to a given commit, or HEAD itself when it is detached.
+func (r *Repository) AdvanceHead(sha string) error {
+	branch, err := r.CurrentBranch()
+	if err != nil {
+		return err
+	}
+
+	if branch == "" {
+		return r.DetachHead(sha)
+	}
+
+	if err := r.writeRef(r.branchPath(branch), sha); err != nil {
+		return fmt.Errorf("advance branch %s: %w", branch, err)
+	}
+	return nil
+}
+
+// DetachHead points HEAD directly to a commit hash.
+func (r *Repository) DetachHead(sha string) error {
+	if err := writeFileAtomic(r.HeadPath(), []byte(sha), 0644); err != nil {
+		return fmt.Errorf("update head: %w", err)
+	}
+	return nil
+}
+
+// SetHeadBranch makes HEAD a symbolic ref pointing to a branch.
+func (r *Repository) SetHeadBranch(name string) error {
+	if err := validateRefName(name); err != nil {
+		return err
+	}
+	ref := symRefPrefix + branchRefPrefix + name
+	if err := writeFileAtomic(r.HeadPath(), []byte(ref), 0644); err != nil {
+		return fmt.Errorf("update head: %w", err)
+	}
+	return nil
+}
+
+// CurrentBranch returns a branch name HEAD points to, or an empty string for a detached HEAD.
+func (r *Repository) CurrentBranch() (string, error) {
+	head, err := r.readHeadFile()
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(head, symRefPrefix) {
+		return "", nil
+	}
+
+	ref := strings.TrimSpace(strings.TrimPrefix(head, symRefPrefix))
+	return strings.TrimPrefix(ref, branchRefPrefix), nil
+}
+
+// ReadBranch reads commit hash a branch points to.
+func (r *Repository) ReadBranch(name string) (string, error) {
+	if err := validateRefName(name); err != nil {
+		return "", err
+	}
+	return r.readRef(r.branchPath(name))
+}
+
+// CreateBranch creates a new branch pointing to a given commit.
+func (r *Repository) CreateBranch(name, commitSha string) error {
+	if err := validateRefName(name); err != nil {
+		return err
+	}
+	if _, err := os.Stat(r.branchPath(name)); err == nil {
+		return fmt.Errorf("create branch %s: %w", name, ErrBranchExists)
+	}
+	if err := r.writeRef(r.branchPath(name), commitSha); err != nil {
+		return fmt.Errorf("create branch %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteBranch removes a branch ref. The branch HEAD points to could not be deleted.
+func (r *Repository) DeleteBranch(name string) error {
+	if err := validateRefName(name); err != nil {
+		return err
+	}
+
+	current, err := r.CurrentBranch()
+	if err != nil {
+		return err
+	}
+	if current == name {
+		return fmt.Errorf("delete branch %s: %w", name, ErrBranchCheckedOut)
+	}
+
+	if err := os.Remove(r.branchPath(name)); err != nil {
+		if os.IsNotExist(err) {
+			err = ErrUnknownRevision
+		}
+		return fmt.Errorf("delete branch %s: %w", name, err)
+	}
+	return nil
+}
+
+// ListBranches returns sorted names of all the repo branches.
+func (r *Repository) ListBranches() ([]string, error) {
+	names, err := listRefs(r.path(headsPath))
+	if err != nil {
+		return nil, fmt.Errorf("list branches: %w", err)
+	}
+	return names, nil
+}
+
+// IsBranch tests whether a branch with a given name exists.
+func (r *Repository) IsBranch(name string) bool {
+	if validateRefName(name) != nil {
+		return false
+	}
+	_, err := os.Stat(r.branchPath(name))
+	return err == nil
+}
+
+// ReadRef turns HEAD, a branch or a tag name into a hash it points to. Any other revision has to be
+// a hash of the repo object format and is returned as is, so it is up to a caller to check such
+// a hash names an existing object. Branches take precedence over tags with the same name.
+func (r *Repository) ReadRef(rev string) (string, error) {
+	if rev == "HEAD" {
+		return r.ReadHead()
+	}
+	if r.IsBranch(rev) {
+		return r.ReadBranch(rev)
+	}
+	if r.IsTag(rev) {
+		return r.ReadTag(rev)
+	}
+	if !r.Hash.IsHash(rev) {
+		return "", fmt.Errorf("%w: %s", ErrUnknownRevision, rev)
+	}
+	return rev, nil
+}
+
+// ReadTag reads a hash a tag points to. Lightweight tags point to commits while annotated
+// tags point to tag objects.
+func (r *Repository) ReadTag(name string) (string, error) {
+	if err := validateRefName(name); err != nil {
+		return "", err
+	}
+	return r.readRef(r.tagPath(name))
+}
+
+// CreateTag creates a new tag pointing to a given commit or tag object.
+func (r *Repository) CreateTag(name, sha string) error {
+	if err := validateRefName(name); err != nil {
+		return err
+	}
+	if _, err := os.Stat(r.tagPath(name)); err == nil {
+		return fmt.Errorf("create tag %s: %w", name, ErrTagExists)
+	}
+	if err := r.writeRef(r.tagPath(name), sha); err != nil {
+		return fmt.Errorf("create tag %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteTag removes a tag ref. Annotated tag object is kept in the object store.
+func (r *Repository) DeleteTag(name string) error {
+	if err := validateRefName(name); err != nil {
+		return err
+	}
+	if err := os.Remove(r.tagPath(name)); err != nil {
+		if os.IsNotExist(err) {
+			err = ErrUnknownRevision
+		}
+		return fmt.Errorf("delete tag %s: %w", name, err)
+	}
+	return nil
+}
+
+// ListTags returns sorted names of all the repo tags.
+func (r *Repository) ListTags() ([]string, error) {
+	names, err := listRefs(r.path(tagsPath))
+	if err != nil {
+		return nil, fmt.Errorf("list tags: %w", err)
+	}
+	return names, nil
+}
+
+// IsTag tests whether a tag with a given name exists.
+func (r *Repository) IsTag(name string) bool {
+	if validateRefName(name) != nil {
+		return false
+	}
+	_, err := os.Stat(r.tagPath(name))
+	return err == nil
+}
+
+// tagPath returns absolute path of a tag ref file.
+func (r *Repository) tagPath(name string) string {
+	return filepath.Join(r.path(tagsPath), filepath.FromSlash(name))
+}
+
+// branchPath returns absolute path of a branch ref file.
+func (r *Repository) branchPath(name string) string {
+	return filepath.Join(r.path(headsPath), filepath.FromSlash(name))
+}
+
+// readHeadFile returns raw HEAD file contents.
+func (r *Repository) readHeadFile() (string, error) {
+	head, err := ioutil.ReadFile(r.HeadPath())
+	if err != nil {
+		return "", fmt.Errorf("read head: %w", err)
+	}
+	return string(head), nil
+}
+
+// writeRef writes a commit hash into a ref file creating nested ref dirs if needed.
+func (r *Repository) writeRef(p, sha string) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(p, []byte(sha), 0644)
+}
+
+// readRef reads a commit hash from a ref file.
+func (r *Repository) readRef(p string) (string, error) {
+	sha, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return r.parseHash(string(sha))
+}
+
+// parseHash checks a ref file holds a hash of the repo object format.
+func (r *Repository) parseHash(s string) (string, error) {
+	sha := strings.TrimSpace(s)
+	if !r.Hash.IsHash(sha) {
+		return "", fmt.Errorf("invalid %s hash %q: %w", r.Hash.Name, sha, ErrRepoCorrupt)
+	}
+	return sha, nil
+}
+
+// listRefs returns sorted ref names found in a refs dir. Nested dirs make slash separated names.
+func listRefs(root string) ([]string, error) {
+	var names []string
+
+	refWalker := func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// skip temp files left by interrupted writes
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+		name, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	}
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err := filepath.Walk(root, refWalker); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// validateRefName checks a branch or tag name could be safely used as a ref file path. Names
+// of ref files could not start with a dot, those are temp files listRefs skips.
+func validateRefName(name string) error {
+	invalid := name == "" ||
+		strings.HasPrefix(name, "-") ||
+		strings.HasPrefix(name, ".") ||
+		strings.Contains(name, "/.") ||
+		strings.HasPrefix(name, "/") ||
+		strings.HasSuffix(name, "/") ||
+		strings.Contains(name, "..") ||
+		strings.Contains(name, "//") ||
+		strings.ContainsAny(name, " \t\n\\:?*[~^")
+
+	if invalid {
+		return fmt.Errorf("%w: %q", ErrInvalidRefName, name)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package got
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var statCachePath string = path.Join(gotPath, "statcache")
+
+// StatEntry is a worktree file stat info along with the file blob hash calculated for it.
+type StatEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Inode   uint64
+	Hash    string
+}
+
+// StatCache keeps worktree file hashes so files which were not changed since they were hashed
+// do not have to be read again.
+type StatCache struct {
+	entries map[string]StatEntry
+	// written is a time the cache was written at, files modified not before it could be changed
+	// again within the file system time precision and are never trusted
+	written time.Time
+}
+
+// NewStatCache returns an empty stat cache.
+func NewStatCache() *StatCache {
+	return &StatCache{entries: make(map[string]StatEntry)}
+}
+
+// Lookup returns a cached hash of a file if its stat info is the same it was when the file was hashed.
+func (c *StatCache) Lookup(p string, fi os.FileInfo) (string, bool) {
+	e, ok := c.entries[p]
+	if !ok {
+		return "", false
+	}
+	if e.Size != fi.Size() || !e.ModTime.Equal(fi.ModTime()) || e.Inode != fileInode(fi) {
+		return "", false
+	}
+	if !e.ModTime.Before(c.written) {
+		return "", false
+	}
+	return e.Hash, true
+}
+
+// Update caches a file hash along with the file stat info.
+func (c *StatCache) Update(p string, fi os.FileInfo, hash string) {
+	c.entries[p] = StatEntry{Path: p, Size: fi.Size(), ModTime: fi.ModTime(), Inode: fileInode(fi), Hash: hash}
+}
+
+// StatCachePath returns absolute stat cache file path.
+func (r *Repository) StatCachePath() string {
+	return r.path(statCachePath)
+}
+
+// ReadStatCache reads the stat cache file. A repo without the file has an empty cache.
+func (r *Repository) ReadStatCache() (*StatCache, error) {
+	c := NewStatCache()
+
+	f, err := os.Open(r.StatCachePath())
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read stat cache: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("read stat cache: %w", err)
+	}
+	c.written = fi.ModTime()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e, err := parseStatEntry(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("read stat cache: %w", err)
+		}
+		c.entries[e.Path] = e
+	}
+
+	return c, scanner.Err()
+}
+
+// WriteStatCache writes the stat cache file. Every entry is a line of tab separated size,
+// modification time, inode, blob hash and path.
+func (r *Repository) WriteStatCache(c *StatCache) error {
+	paths := make([]string, 0, len(c.entries))
+	for p := range c.entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	for _, p := range paths {
+		e := c.entries[p]
+		fmt.Fprintf(&buf, "%d\t%d\t%d\t%s\t%s\n", e.Size, e.ModTime.UnixNano(), e.Inode, e.Hash, filepath.ToSlash(e.Path))
+	}
+
+	if err := writeFileAtomic(r.StatCachePath(), buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write stat cache: %w", err)
+	}
+	return nil
+}
+
+// parseStatEntry parses a stat cache file line.
+func parseStatEntry(line string) (StatEntry, error) {
+	fields := strings.SplitN(line, "\t", 5)
+	if len(fields) != 5 {
+		return StatEntry{}, fmt.Errorf("invalid stat cache entry %q", line)
+	}
+
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return StatEntry{}, fmt.Errorf("invalid stat cache entry size %q: %w", line, err)
+	}
+	mtime, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return StatEntry{}, fmt.Errorf("invalid stat cache entry time %q: %w", line, err)
+	}
+	inode, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return StatEntry{}, fmt.Errorf("invalid stat cache entry inode %q: %w", line, err)
+	}
+
+	return StatEntry{
+		Size:    size,
+		ModTime: time.Unix(0, mtime),
+		Inode:   inode,
+		Hash:    fields[3],
+		Path:    filepath.FromSlash(fields[4]),
+	}, nil
+}
@@ -0,0 +1,67 @@
+package got
+
+import "time"
+
+// RawObject is an object in the form it is kept by an object store: its archived content
+// along with the metadata fields written into the archive header.
+type RawObject struct {
+	Name    string
+	Comment string
+	ModTime time.Time
+	Data    []byte
+}
+
+// ObjectStore is a storage backend for repo objects. Objects are addressed by their type name
+// (commit, tree, blob or tag) and hash string.
+type ObjectStore interface {
+	// Get returns a stored object or an error wrapping ErrObjDoesNotExist.
+	Get(objType, hash string) (*RawObject, error)
+	// Put stores an object replacing any object with the same type and hash.
+	Put(objType, hash string, obj *RawObject) error
+	// Has reports whether an object is stored.
+	Has(objType, hash string) (bool, error)
+	// Delete removes a stored object. Deleting a missing object is not an error. Stores
+	// implementing Packer could keep packed objects until they are repacked.
+	Delete(objType, hash string) error
+	// Iterate calls fn for every stored object hash of a given type. Iteration stops
+	// on the first error returned by fn.
+	Iterate(objType string, fn func(hash string) error) error
+}
+
+// Packer is implemented by object stores able to pack objects into a single file.
+type Packer interface {
+	// Repack moves stored objects accepted by the options into a single pack dropping the rest,
+	// and returns a number of packed objects.
+	Repack(opts RepackOptions) (int, error)
+}
+
+// RepackOptions configure Repack.
+type RepackOptions struct {
+	// Keep tells whether an object should be packed, objects not accepted are dropped.
+	// Nil keeps every object.
+	Keep func(objType, hash string) bool
+	// Recompress rewrites every archive with the store compression, otherwise archives
+	// are copied as is.
+	Recompress bool
+}
+
+// Compressor is implemented by object stores compressing objects they keep.
+type Compressor interface {
+	// SetCompression sets a compression objects are written with from now on. Objects written
+	// earlier keep their compression until they are recompressed.
+	SetCompression(c Compression)
+}
+
+// Rewriter is implemented by object stores able to rewrite every object at once. A rewrite is
+// staged first and published then, so stored objects are never partially rewritten.
+type Rewriter interface {
+	// StageRewrite reads every stored object, passes it through fn and stages the results
+	// replacing objects staged earlier. Nothing is staged unless fn succeeds for every object.
+	// Staged objects are not read until they are published. It returns a number of staged objects.
+	StageRewrite(fn func(objType, hash string, obj *RawObject) (*RawObject, error)) (int, error)
+	// Staged reports whether there are staged objects.
+	Staged() (bool, error)
+	// PublishStaged replaces every stored object with the staged ones. It is safe to call it again
+	// after it is interrupted and does nothing when nothing is staged.
+	PublishStaged() error
+}
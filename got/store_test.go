@@ -0,0 +1,451 @@
+package got
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObjectStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, objType := range []string{"commit", "tree", "blob"} {
+		if err := os.Mkdir(filepath.Join(dir, objType), 0755); err != nil {
+			t.Fatalf("create objects dir: %v", err)
+		}
+	}
+
+	stores := map[string]ObjectStore{
+		"file":   NewFileStore(dir),
+		"memory": NewMemoryStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			testObjectStore(t, store)
+		})
+	}
+}
+
+func testObjectStore(t *testing.T, store ObjectStore) {
+	obj := &RawObject{
+		Name:    "sample.file",
+		Comment: "message",
+		ModTime: time.Unix(1577836800, 0),
+		Data:    []byte("some data here"),
+	}
+
+	if ok, err := store.Has("blob", "bb"); err != nil || ok {
+		t.Fatalf("expected no object before put, got %v, %v", ok, err)
+	}
+	if _, err := store.Get("blob", "bb"); !errors.Is(err, ErrObjDoesNotExist) {
+		t.Fatalf("expected %v, got %v", ErrObjDoesNotExist, err)
+	}
+
+	for _, hash := range []string{"bb", "aa"} {
+		if err := store.Put("blob", hash, obj); err != nil {
+			t.Fatalf("put object: %v", err)
+		}
+	}
+
+	if ok, err := store.Has("blob", "bb"); err != nil || !ok {
+		t.Fatalf("expected object after put, got %v, %v", ok, err)
+	}
+	if ok, _ := store.Has("tree", "bb"); ok {
+		t.Fatal("expected object types to be separated")
+	}
+
+	res, err := store.Get("blob", "bb")
+	if err != nil {
+		t.Fatalf("get object: %v", err)
+	}
+	if res.Name != obj.Name || res.Comment != obj.Comment || !res.ModTime.Equal(obj.ModTime) || string(res.Data) != string(obj.Data) {
+		t.Fatalf("expected %+v, got %+v", obj, res)
+	}
+
+	var hashes []string
+	err = store.Iterate("blob", func(hash string) error {
+		hashes = append(hashes, hash)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("iterate objects: %v", err)
+	}
+	if !reflect.DeepEqual(hashes, []string{"aa", "bb"}) {
+		t.Fatalf("expected hashes [aa bb], got %v", hashes)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := store.Delete("blob", "aa"); err != nil {
+			t.Fatalf("delete object: %v", err)
+		}
+	}
+	if ok, err := store.Has("blob", "aa"); err != nil || ok {
+		t.Fatalf("expected no object after delete, got %v, %v", ok, err)
+	}
+	if ok, _ := store.Has("blob", "bb"); !ok {
+		t.Fatal("expected other objects to be kept")
+	}
+}
+
+func TestFileStorePacks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewFileStore(dir)
+
+	for _, hash := range []string{"aa", "bb", "cc"} {
+		obj := &RawObject{Name: hash, Data: []byte("data " + hash)}
+		if err := store.Put("blob", hash, obj); err != nil {
+			t.Fatalf("put object: %v", err)
+		}
+	}
+	if err := store.Put("tree", "aa", &RawObject{Name: "tree", Data: []byte("tree data")}); err != nil {
+		t.Fatalf("put object: %v", err)
+	}
+
+	packed, err := store.Repack(RepackOptions{Keep: func(objType, hash string) bool { return hash != "cc" }})
+	if err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+	if packed != 3 {
+		t.Fatalf("expected 3 packed objects, got %d", packed)
+	}
+	if loose, _ := ioutil.ReadDir(filepath.Join(dir, "blob")); len(loose) != 0 {
+		t.Fatalf("expected no loose objects after repack, got %d", len(loose))
+	}
+	if ok, _ := store.Has("blob", "cc"); ok {
+		t.Fatal("expected rejected object to be dropped")
+	}
+
+	res, err := store.Get("blob", "bb")
+	if err != nil {
+		t.Fatalf("get packed object: %v", err)
+	}
+	if res.Name != "bb" || string(res.Data) != "data bb" {
+		t.Fatalf("expected packed object bb, got %+v", res)
+	}
+	if res, err := store.Get("tree", "aa"); err != nil || string(res.Data) != "tree data" {
+		t.Fatalf("expected packed tree, got %+v, %v", res, err)
+	}
+
+	if err := store.Put("blob", "dd", &RawObject{Data: []byte("data dd")}); err != nil {
+		t.Fatalf("put object: %v", err)
+	}
+	var hashes []string
+	err = store.Iterate("blob", func(hash string) error {
+		hashes = append(hashes, hash)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("iterate objects: %v", err)
+	}
+	if !reflect.DeepEqual(hashes, []string{"aa", "bb", "dd"}) {
+		t.Fatalf("expected loose and packed hashes [aa bb dd], got %v", hashes)
+	}
+
+	if _, err := store.Repack(RepackOptions{}); err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+	packs, _ := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+	if len(packs) != 1 {
+		t.Fatalf("expected packs to be merged into one, got %v", packs)
+	}
+	if res, err := store.Get("blob", "dd"); err != nil || string(res.Data) != "data dd" {
+		t.Fatalf("expected repacked object dd, got %+v, %v", res, err)
+	}
+}
+
+func TestDelta(t *testing.T) {
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, fmt.Sprintf("setting_%d = value %d", i, i*i))
+	}
+	base := []byte(strings.Join(lines, "\n"))
+	lines[100] = "setting_100 = changed"
+	lines = append(lines[:20], lines[30:]...)
+	target := []byte("# header\n" + strings.Join(lines, "\n") + "\n# footer")
+
+	cases := map[string][2][]byte{
+		"edited":      {base, target},
+		"empty base":  {nil, target},
+		"empty":       {base, nil},
+		"unrelated":   {[]byte("abc"), []byte("xyz")},
+		"same":        {base, base},
+		"short block": {[]byte("0123456789abcdef"), []byte("0123456789abcdef!")},
+	}
+	for name, c := range cases {
+		delta := makeDelta(c[0], c[1])
+		res, err := applyDelta(c[0], delta)
+		if err != nil {
+			t.Fatalf("%s: apply delta: %v", name, err)
+		}
+		if !bytes.Equal(res, c[1]) {
+			t.Fatalf("%s: expected delta to rebuild target, got %q", name, res)
+		}
+	}
+
+	if delta := makeDelta(base, target); len(delta) > len(target)/10 {
+		t.Fatalf("expected a small delta for an edited file, got %d bytes for %d bytes target", len(delta), len(target))
+	}
+	if _, err := applyDelta(base[1:], makeDelta(base, target)); !errors.Is(err, errInvalidDelta) {
+		t.Fatalf("expected %v for a wrong base, got %v", errInvalidDelta, err)
+	}
+}
+
+func TestFileStoreDeltaPacks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewFileStore(dir)
+
+	var versions [][]byte
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprintf("key_%d: %x", i, i*7919))
+	}
+	for v := 0; v < 4; v++ {
+		lines[v*100] = fmt.Sprintf("key_%d: version %d", v*100, v)
+		data := []byte(strings.Join(lines, "\n"))
+		versions = append(versions, data)
+		obj := &RawObject{Name: "config.yml", ModTime: time.Unix(int64(1577836800+v), 0), Data: data}
+		if err := store.Put("blob", fmt.Sprintf("%02d", v), obj); err != nil {
+			t.Fatalf("put object: %v", err)
+		}
+	}
+
+	if _, err := store.Repack(RepackOptions{}); err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+	// repacking a pack of deltas rebuilds them
+	if _, err := store.Repack(RepackOptions{Keep: func(objType, hash string) bool { return hash != "00" }}); err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+
+	packs, err := loadPacks(filepath.Join(dir, "pack"))
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("expected a single pack, got %v, %v", packs, err)
+	}
+	var deltas int
+	for _, e := range packs[0].entries {
+		if e.base != "" {
+			deltas++
+			if e.length > int64(len(versions[0])/10) {
+				t.Fatalf("expected delta %s to be small, got %d bytes", e.hash, e.length)
+			}
+		}
+	}
+	if deltas != 2 {
+		t.Fatalf("expected 2 delta encoded versions, got %d in %+v", deltas, packs[0].entries)
+	}
+
+	for v := 1; v < 4; v++ {
+		res, err := store.Get("blob", fmt.Sprintf("%02d", v))
+		if err != nil {
+			t.Fatalf("get version %d: %v", v, err)
+		}
+		if !bytes.Equal(res.Data, versions[v]) || res.Name != "config.yml" {
+			t.Fatalf("expected version %d to be rebuilt from delta", v)
+		}
+	}
+}
+
+func TestFileStoreStageRewrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	repo, err := Init(dir)
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	store := repo.Store.(*FileStore)
+
+	var versions [][]byte
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprintf("key_%d: %x", i, i*7919))
+	}
+	for v := 0; v < 3; v++ {
+		lines[v*100] = fmt.Sprintf("key_%d: version %d", v*100, v)
+		versions = append(versions, []byte(strings.Join(lines, "\n")))
+		obj := &RawObject{Name: "config.yml", ModTime: time.Unix(int64(1577836800+v), 0), Data: versions[v]}
+		if err := store.Put("blob", fmt.Sprintf("%02d", v), obj); err != nil {
+			t.Fatalf("put object: %v", err)
+		}
+	}
+	if _, err := store.Repack(RepackOptions{}); err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+	// a loose object shadowing a packed one is rewritten too
+	if err := store.Put("blob", "02", &RawObject{Name: "config.yml", ModTime: time.Unix(1577836802, 0), Data: versions[2]}); err != nil {
+		t.Fatalf("put object: %v", err)
+	}
+
+	upper := func(objType, hash string, obj *RawObject) (*RawObject, error) {
+		return &RawObject{Data: bytes.ToUpper(obj.Data)}, nil
+	}
+	failing := func(objType, hash string, obj *RawObject) (*RawObject, error) {
+		if hash == "02" {
+			return nil, errors.New("broken object")
+		}
+		return upper(objType, hash, obj)
+	}
+	if _, err := store.StageRewrite(failing); err == nil {
+		t.Fatal("expected a failed rewrite to fail")
+	}
+	if staged, err := store.Staged(); err != nil || staged {
+		t.Fatalf("expected nothing to be staged after a failed rewrite, got %v, %v", staged, err)
+	}
+
+	if n, err := store.StageRewrite(upper); err != nil || n != 3 {
+		t.Fatalf("expected 3 objects to be staged, got %d, %v", n, err)
+	}
+	if res, err := store.Get("blob", "01"); err != nil || !bytes.Equal(res.Data, versions[1]) {
+		t.Fatalf("expected staged objects not to be read before they are published, got %v", err)
+	}
+
+	// the repo records object headers already, so it is opened as one a migration was
+	// interrupted in before publishing objects
+	if repo, err = Open(dir); err != nil {
+		t.Fatalf("open repo: %v", err)
+	}
+	if staged, err := repo.Store.(Rewriter).Staged(); err != nil || staged {
+		t.Fatalf("expected staged objects to be published on open, got %v, %v", staged, err)
+	}
+	for v := 0; v < 3; v++ {
+		res, err := repo.Store.Get("blob", fmt.Sprintf("%02d", v))
+		if err != nil {
+			t.Fatalf("get version %d: %v", v, err)
+		}
+		if !bytes.Equal(res.Data, bytes.ToUpper(versions[v])) {
+			t.Fatalf("expected version %d to be rewritten", v)
+		}
+	}
+	if loose, err := repo.Store.(*FileStore).loosePaths(); err != nil || len(loose) != 0 {
+		t.Fatalf("expected loose objects to be removed, got %v, %v", loose, err)
+	}
+	packs, err := loadPacks(filepath.Join(dir, ".got", "objects", "pack"))
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("expected a single pack, got %v, %v", packs, err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	if _, err := ParseCompression("gzip:12"); err == nil {
+		t.Fatalf("expected an out of range gzip level to be refused")
+	}
+	if _, err := ParseCompression("lz4"); err == nil {
+		t.Fatalf("expected an unknown codec to be refused")
+	}
+
+	obj := &RawObject{Name: "main.go", Comment: "message", ModTime: time.Unix(1577836800, 0), Data: bytes.Repeat([]byte("package main\n"), 100)}
+	for _, s := range []string{"gzip:1", "gzip:9", "zstd:1", "zstd:19", "none"} {
+		c, err := ParseCompression(s)
+		if err != nil {
+			t.Fatalf("parse compression %s: %v", s, err)
+		}
+		if c.String() != s {
+			t.Fatalf("expected compression %s to be printed as is, got %s", s, c)
+		}
+		archive, err := encodeArchive(obj, c)
+		if err != nil {
+			t.Fatalf("encode %s archive: %v", s, err)
+		}
+		if archiveCodec(archive) != c.Codec {
+			t.Fatalf("expected %s archive codec, got %q", c.Codec, archiveCodec(archive))
+		}
+		res, err := decodeArchive(bytes.NewReader(archive), s)
+		if err != nil {
+			t.Fatalf("decode %s archive: %v", s, err)
+		}
+		if !bytes.Equal(res.Data, obj.Data) || res.Name != obj.Name || res.Comment != obj.Comment || !res.ModTime.Equal(obj.ModTime) {
+			t.Fatalf("expected %s archive to keep the object, got %+v", s, res)
+		}
+	}
+}
+
+func TestFileStoreRecompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewFileStore(dir)
+
+	data := map[string][]byte{}
+	for i, hash := range []string{"aa", "bb", "cc"} {
+		data[hash] = bytes.Repeat([]byte(fmt.Sprintf("line %d\n", i)), 50)
+		if err := store.Put("blob", hash, &RawObject{Name: hash, Data: data[hash]}); err != nil {
+			t.Fatalf("put object: %v", err)
+		}
+	}
+	if _, err := store.Repack(RepackOptions{}); err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+
+	// new objects are written with a new codec while old ones stay gzip until recompressed
+	store.SetCompression(Compression{Codec: CodecZstd, Level: 3})
+	data["dd"] = []byte("zstd object")
+	if err := store.Put("blob", "dd", &RawObject{Name: "dd", Data: data["dd"]}); err != nil {
+		t.Fatalf("put object: %v", err)
+	}
+	if _, err := store.Repack(RepackOptions{}); err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+	codecs := packCodecs(t, dir)
+	if codecs["aa"] != CodecGzip || codecs["dd"] != CodecZstd {
+		t.Fatalf("expected mixed gzip and zstd archives, got %v", codecs)
+	}
+
+	if _, err := store.Repack(RepackOptions{Recompress: true}); err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+	for hash, codec := range packCodecs(t, dir) {
+		if codec != CodecZstd {
+			t.Fatalf("expected %s to be recompressed with zstd, got %s", hash, codec)
+		}
+	}
+	for hash, want := range data {
+		res, err := store.Get("blob", hash)
+		if err != nil {
+			t.Fatalf("get object %s: %v", hash, err)
+		}
+		if !bytes.Equal(res.Data, want) {
+			t.Fatalf("expected object %s to survive recompression", hash)
+		}
+	}
+}
+
+// packCodecs returns codecs of archives in a single store pack by object hash.
+func packCodecs(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	packs, err := loadPacks(filepath.Join(dir, "pack"))
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("expected a single pack, got %v, %v", packs, err)
+	}
+	codecs := make(map[string]string)
+	for _, e := range packs[0].entries {
+		archive, err := packs[0].read(e)
+		if err != nil {
+			t.Fatalf("read packed object: %v", err)
+		}
+		codecs[e.hash] = archiveCodec(archive)
+	}
+	return codecs
+}
@@ -0,0 +1,215 @@
+// Package ignore implements gitignore-style path matching for .gotignore files.
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is a name of files holding ignore patterns.
+const FileName = ".gotignore"
+
+// DefaultSource is a source name of rules built from default ignore entries.
+const DefaultSource = "<default>"
+
+// Rule is a single ignore pattern along with the place it was read from.
+type Rule struct {
+	Pattern  string
+	Source   string
+	Line     int
+	Negate   bool
+	DirOnly  bool
+	Anchored bool
+
+	base     string
+	segments []string
+}
+
+// String returns a rule description in the source:line:pattern form.
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s:%d:%s", r.Source, r.Line, r.Pattern)
+}
+
+// Matcher holds ignore rules of a worktree. Paths passed to the matcher are slash separated
+// and relative to the worktree root.
+type Matcher struct {
+	root   string
+	rules  []*Rule
+	loaded map[string]bool
+}
+
+// New returns a matcher with default patterns and patterns of the root .gotignore file.
+func New(root string, defaults []string) (*Matcher, error) {
+	m := &Matcher{root: root, loaded: make(map[string]bool)}
+	for i, pattern := range defaults {
+		if r, ok := parseRule(pattern, ""); ok {
+			r.Source, r.Line = DefaultSource, i+1
+			m.rules = append(m.rules, r)
+		}
+	}
+	if err := m.AddDir(""); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AddDir loads patterns of a .gotignore file in a given dir if there is one. Patterns of nested
+// dirs take precedence over patterns of their parents, so dirs should be added top down.
+func (m *Matcher) AddDir(dir string) error {
+	if m.loaded[dir] {
+		return nil
+	}
+	m.loaded[dir] = true
+
+	source := path.Join(dir, FileName)
+	f, err := os.Open(filepath.Join(m.root, filepath.FromSlash(source)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read %s: %w", source, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if r, ok := parseRule(scanner.Text(), dir); ok {
+			r.Source, r.Line = source, line
+			m.rules = append(m.rules, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %w", source, err)
+	}
+	return nil
+}
+
+// Match returns the last rule matching a path itself without looking at its parent dirs,
+// or nil if there is none. It fits worktree walkers which skip ignored dirs anyway.
+func (m *Matcher) Match(p string, isDir bool) *Rule {
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		if m.rules[i].matches(p, isDir) {
+			return m.rules[i]
+		}
+	}
+	return nil
+}
+
+// Ignored tests whether a path itself is ignored.
+func (m *Matcher) Ignored(p string, isDir bool) bool {
+	r := m.Match(p, isDir)
+	return r != nil && !r.Negate
+}
+
+// Explain returns a rule deciding whether a path is ignored. A path inside an ignored dir is
+// ignored by the dir rule. Ignore files of all the parent dirs are loaded on the way.
+func (m *Matcher) Explain(p string, isDir bool) (*Rule, error) {
+	parts := strings.Split(p, "/")
+	dir := ""
+	if err := m.AddDir(dir); err != nil {
+		return nil, err
+	}
+
+	for _, part := range parts[:len(parts)-1] {
+		dir = path.Join(dir, part)
+		if r := m.Match(dir, true); r != nil && !r.Negate {
+			return r, nil
+		}
+		if err := m.AddDir(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.Match(p, isDir), nil
+}
+
+// parseRule parses a pattern line of an ignore file located in a given dir.
+func parseRule(line, base string) (*Rule, bool) {
+	pattern := strings.TrimRight(line, " \t")
+	if strings.HasSuffix(pattern, "\\") && len(pattern) < len(line) {
+		pattern += " "
+	}
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil, false
+	}
+
+	r := &Rule{Pattern: pattern, base: base}
+
+	if strings.HasPrefix(pattern, "!") {
+		r.Negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, "\\#") || strings.HasPrefix(pattern, "\\!") {
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		r.DirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	if strings.Contains(pattern, "/") {
+		r.Anchored = true
+		pattern = strings.TrimLeft(pattern, "/")
+	}
+
+	if pattern == "" {
+		return nil, false
+	}
+
+	r.segments = strings.Split(pattern, "/")
+	return r, true
+}
+
+// matches tests whether a rule matches a path.
+func (r *Rule) matches(p string, isDir bool) bool {
+	if r.DirOnly && !isDir {
+		return false
+	}
+
+	rel := p
+	if r.base != "" {
+		if !strings.HasPrefix(p, r.base+"/") {
+			return false
+		}
+		rel = p[len(r.base)+1:]
+	}
+
+	if !r.Anchored {
+		ok, _ := path.Match(r.segments[0], path.Base(rel))
+		return ok
+	}
+
+	return matchSegments(r.segments, strings.Split(rel, "/"))
+}
+
+// matchSegments matches path parts against pattern segments where ** stands for any number of dirs.
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+
+	if pattern[0] == "**" {
+		// trailing ** matches everything inside a dir but not the dir itself
+		if len(pattern) == 1 {
+			return len(parts) > 0
+		}
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
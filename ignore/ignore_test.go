@@ -0,0 +1,78 @@
+package ignore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	root, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		FileName:                       "# build artifacts\n*.o\n/build/\nlogs/**\n!important.o\ndocs/**/*.tmp\n\\#hash\n",
+		filepath.Join("lib", FileName): "generated.go\n!keep.o\n/local.txt\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("write %v: %v", name, err)
+		}
+	}
+
+	m, err := New(root, []string{".got"})
+	if err != nil {
+		t.Fatalf("new matcher: %v", err)
+	}
+
+	cases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+		rule    string
+	}{
+		{".got", true, true, "<default>:1:.got"},
+		{"main.o", false, true, ".gotignore:2:*.o"},
+		{"app/deep/main.o", false, true, ".gotignore:2:*.o"},
+		{"important.o", false, false, ".gotignore:5:!important.o"},
+		{"build", true, true, ".gotignore:3:/build/"},
+		{"build/out.bin", false, true, ".gotignore:3:/build/"},
+		{"build", false, false, ""},
+		{"app/build", true, false, ""},
+		{"logs", true, false, ""},
+		{"logs/today/app.log", false, true, ".gotignore:4:logs/**"},
+		{"docs/a.tmp", false, true, ".gotignore:6:docs/**/*.tmp"},
+		{"docs/a/b/c.tmp", false, true, ".gotignore:6:docs/**/*.tmp"},
+		{"app/docs/a.tmp", false, false, ""},
+		{"#hash", false, true, ".gotignore:7:\\#hash"},
+		{"lib/generated.go", false, true, "lib/.gotignore:1:generated.go"},
+		{"lib/sub/generated.go", false, true, "lib/.gotignore:1:generated.go"},
+		{"generated.go", false, false, ""},
+		{"lib/keep.o", false, false, "lib/.gotignore:2:!keep.o"},
+		{"lib/local.txt", false, true, "lib/.gotignore:3:/local.txt"},
+		{"lib/sub/local.txt", false, false, ""},
+	}
+
+	for _, c := range cases {
+		r, err := m.Explain(c.path, c.isDir)
+		if err != nil {
+			t.Fatalf("explain %v: %v", c.path, err)
+		}
+		ignored := r != nil && !r.Negate
+		var rule string
+		if r != nil {
+			rule = r.String()
+		}
+		if ignored != c.ignored || rule != c.rule {
+			t.Errorf("%v: expected ignored %v by %q, got %v by %q", c.path, c.ignored, c.rule, ignored, rule)
+		}
+	}
+}
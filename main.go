@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/shved/got/got"
@@ -11,69 +15,778 @@ import (
 	"github.com/shved/got/worktree"
 )
 
+// Exit codes returned by got on failures.
+const (
+	exitFailure = 1
+	exitNotRepo = 2
+	exitExists  = 3
+	exitNoObj   = 4
+	exitCorrupt = 5
+	exitLocked  = 6
+	exitChanges = 7
+)
+
+var jobs = flag.Int("jobs", 0, "number of goroutines hashing and writing objects, 0 means core.workers or GOMAXPROCS")
+
 var blankRepoCommands = []string{
 	"",
 	"init",
 	"help",
+	"config",
 }
 
-func init() {
+func main() {
 	flag.Parse()
+
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "got:", err)
+		os.Exit(exitCode(err))
+	}
 }
 
-func main() {
-	command := flag.Arg(0)
+func run(command string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
 
+	var repo *got.Repository
 	if !blankRepoCommand(command) {
-		got.SetRepoRoot()
+		if repo, err = got.Discover(cwd); err != nil {
+			return err
+		}
+		if *jobs != 0 {
+			repo.Workers = *jobs
+		}
 	}
 
 	switch command {
 	case "init":
-		got.InitRepo()
-		fmt.Println("Repo created in a current working directory")
+		return initCommand(cwd, flag.Args()[1:])
+	case "config":
+		return configCommand(cwd, flag.Args()[1:])
 	case "commit":
-		message := flag.Arg(1)
-		if message == "" {
-			fmt.Println("No commit message provided")
-			os.Exit(0)
-		}
-		worktree.MakeCommit(message, time.Now())
-		fmt.Println("Worktree commited:", got.ReadHead())
+		return commit(repo, flag.Args()[1:])
 	case "to":
-		shaString := flag.Arg(1)
-		if shaString == "" {
-			fmt.Println("No commit hash provided")
-			os.Exit(0)
+		return to(repo, flag.Args()[1:])
+	case "add":
+		paths, err := repoPaths(repo, cwd, flag.Args()[1:])
+		if err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			fmt.Println("No paths provided")
+			return nil
 		}
-		worktree.ToCommit(shaString)
-		fmt.Println("Worktree restored from commit:", shaString)
+		return worktree.Add(repo, paths)
+	case "reset":
+		paths, err := repoPaths(repo, cwd, flag.Args()[1:])
+		if err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		return worktree.Reset(repo, paths)
+	case "check-ignore":
+		paths, err := repoPaths(repo, cwd, flag.Args()[1:])
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			rule, err := worktree.CheckIgnore(repo, p)
+			if err != nil {
+				return err
+			}
+			switch {
+			case rule == nil:
+				fmt.Printf("%s\tnot ignored\n", filepath.ToSlash(p))
+			case rule.Negate:
+				fmt.Printf("%s\t%s (not ignored)\n", filepath.ToSlash(p), rule)
+			default:
+				fmt.Printf("%s\t%s\n", filepath.ToSlash(p), rule)
+			}
+		}
+	case "status":
+		return status(repo, flag.Args()[1:])
+	case "diff":
+		return diffCommand(repo, flag.Args()[1:])
+	case "branch":
+		return branch(repo, flag.Args()[1:])
+	case "tag":
+		return tag(repo, flag.Args()[1:])
+	case "gc":
+		return gc(repo, flag.Args()[1:])
+	case "fsck":
+		return fsck(repo)
+	case "repack":
+		return repack(repo, flag.Args()[1:])
+	case "migrate":
+		return migrate(repo)
 	case "show":
-		shaString := flag.Arg(1)
-		if shaString == "" {
-			fmt.Println("No commit hash provided")
-			os.Exit(0)
+		rev := flag.Arg(1)
+		if rev == "" {
+			fmt.Println("No commit hash or tag provided")
+			return nil
+		}
+		content, err := object.Show(repo, rev)
+		if err != nil {
+			return err
 		}
-		fmt.Println(object.Show(shaString))
+		fmt.Println(content)
 	case "log":
-		fmt.Println(got.ReadLog())
+		logs, err := object.Log(repo)
+		if err != nil {
+			return err
+		}
+		fmt.Println(logs)
 	case "current":
-		fmt.Println("Current commit hash:", got.ReadHead())
-	case "help":
-		printHelpMessage()
-		os.Exit(0)
+		head, err := repo.ReadHead()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Current commit hash:", head)
+		branch, err := repo.CurrentBranch()
+		if err != nil {
+			return err
+		}
+		if branch != "" {
+			fmt.Println("Current branch:", branch)
+		}
 	default:
 		printHelpMessage()
-		os.Exit(0)
+	}
+
+	return nil
+}
+
+// initCommand inits a repo in the working directory. Objects are named by SHA-1 hashes unless
+// another --object-format is given.
+func initCommand(cwd string, args []string) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	format := flags.String("object-format", got.SHA1.Name, "object hash algorithm, sha1 or sha256")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	algo, err := got.HashAlgorithmByName(*format)
+	if err != nil {
+		return err
+	}
+	if _, err := got.InitWithHash(cwd, algo); err != nil {
+		return err
+	}
+	fmt.Println("Repo created in a current working directory")
+	return nil
+}
+
+// configCommand gets, sets, unsets and lists config values. Values are read from all the config
+// files merged and written into the repo config, unless a scope flag picks a single file.
+// The command works outside of repos for system and user configs.
+func configCommand(cwd string, args []string) error {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	system := flags.Bool("system", false, "use the system config "+got.SystemConfigPath)
+	user := flags.Bool("user", false, "use the user config ~/.gotconfig")
+	local := flags.Bool("repo", false, "use the repo config .got/config")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var repoConfigPath string
+	repo, err := got.Discover(cwd)
+	switch {
+	case err == nil:
+		repoConfigPath = repo.ConfigPath()
+	case !errors.Is(err, got.ErrNotGotRepo) || *local:
+		return err
+	}
+
+	// a single config file to work with, the merged config is read when it is empty
+	var path string
+	switch {
+	case *system:
+		path = got.SystemConfigPath
+	case *user:
+		path = got.UserConfigPath()
+	case *local:
+		path = repoConfigPath
+	}
+
+	action, name := flags.Arg(0), flags.Arg(1)
+	if action != "list" && name == "" {
+		fmt.Println("No config name provided")
+		return nil
+	}
+
+	switch action {
+	case "list":
+		if path != "" {
+			file, err := got.ReadConfigFile(path)
+			if err != nil {
+				return err
+			}
+			for _, e := range file.List() {
+				fmt.Printf("%s=%s\n", e.Name, e.Value)
+			}
+			return nil
+		}
+		config, err := got.ReadConfig(repoConfigPath)
+		if err != nil {
+			return err
+		}
+		for _, e := range config.List() {
+			fmt.Printf("%s\t%s=%s\n", e.Scope, e.Name, e.Value)
+		}
+	case "get":
+		var value string
+		var ok bool
+		if path != "" {
+			file, err := got.ReadConfigFile(path)
+			if err != nil {
+				return err
+			}
+			value, ok = file.Get(name)
+		} else {
+			config, err := got.ReadConfig(repoConfigPath)
+			if err != nil {
+				return err
+			}
+			value, ok = config.Get(name)
+		}
+		if !ok {
+			return fmt.Errorf("config %s: %w", name, got.ErrNoConfigValue)
+		}
+		fmt.Println(value)
+	case "set", "unset":
+		if action == "set" && flags.NArg() < 3 {
+			fmt.Println("No config value provided")
+			return nil
+		}
+		// the repo compression is a format value new objects are written with
+		if strings.EqualFold(name, "core.compression") && (path == "" || path == repoConfigPath) {
+			return setCompression(repo, action, flags.Arg(2))
+		}
+		if got.IsFormatConfig(name) && path != "" && path != repoConfigPath {
+			return fmt.Errorf("config %s: repo format values are read from the repo config only", name)
+		}
+		if got.IsFormatConfig(name) {
+			return fmt.Errorf("config %s: repo format values are changed by got init, got repack and got migrate only", name)
+		}
+		if path == "" {
+			if repo == nil {
+				return got.ErrNotGotRepo
+			}
+			path = repoConfigPath
+		}
+
+		edit := func() error {
+			file, err := got.ReadConfigFile(path)
+			if err != nil {
+				return err
+			}
+			if action == "set" {
+				if err := file.Set(name, flags.Arg(2)); err != nil {
+					return err
+				}
+			} else if found, err := file.Unset(name); err != nil || !found {
+				if err == nil {
+					err = fmt.Errorf("config %s: %w", name, got.ErrNoConfigValue)
+				}
+				return err
+			}
+			return file.Write()
+		}
+		if path == repoConfigPath {
+			return repo.WithLock(edit)
+		}
+		return edit()
+	default:
+		fmt.Println("Unknown config action, expected get, set, unset or list")
+	}
+	return nil
+}
+
+// to restores the worktree from a commit. Uncommitted changes are discarded only with --force
+// or after a confirmation when got is run in a terminal.
+func to(repo *got.Repository, args []string) error {
+	flags := flag.NewFlagSet("to", flag.ContinueOnError)
+	force := flags.Bool("force", false, "discard uncommitted changes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	rev := flags.Arg(0)
+	if rev == "" {
+		fmt.Println("No commit hash or branch provided")
+		return nil
+	}
+
+	err := worktree.ToCommit(repo, rev, *force)
+	var changesErr *worktree.LocalChangesError
+	if errors.As(err, &changesErr) && isTerminal(os.Stdin) {
+		fmt.Println(changesErr)
+		if !confirm("Discard these changes?") {
+			return changesErr
+		}
+		err = worktree.ToCommit(repo, rev, true)
+	}
+	if err != nil {
+		return err
+	}
+
+	if repo.IsBranch(rev) {
+		fmt.Println("Switched to branch:", rev)
+	} else {
+		fmt.Println("Worktree restored from commit:", rev)
+	}
+	return nil
+}
+
+// isTerminal tests whether a file is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks a yes/no question on stdin, no is the default answer.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// status prints worktree changes relative to the HEAD commit.
+func status(repo *got.Repository, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	porcelain := flags.Bool("porcelain", false, "machine-readable output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	statuses, err := worktree.Status(repo)
+	if err != nil {
+		return err
+	}
+
+	if *porcelain {
+		for _, st := range statuses {
+			fmt.Printf("%s %s\n", st.State.Code(), filepath.ToSlash(st.Path))
+		}
+		return nil
+	}
+
+	branch, err := repo.CurrentBranch()
+	if err != nil {
+		return err
+	}
+	if branch != "" {
+		fmt.Println("On branch", branch)
+	} else {
+		head, err := repo.ReadHead()
+		if err != nil {
+			return err
+		}
+		fmt.Println("HEAD detached at", head)
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("Nothing to commit, worktree clean")
+		return nil
+	}
+
+	fmt.Println("Changes:")
+	for _, st := range statuses {
+		fmt.Printf("\t%-9s %s\n", st.State.String()+":", filepath.ToSlash(st.Path))
+	}
+	return nil
+}
+
+// diffCommand prints a diff between two commits, or between a commit (HEAD by default) and the worktree.
+func diffCommand(repo *got.Repository, args []string) error {
+	var diffs []worktree.FileDiff
+	var err error
+
+	switch len(args) {
+	case 0:
+		diffs, err = worktree.DiffWorktree(repo, "")
+	case 1:
+		diffs, err = worktree.DiffWorktree(repo, args[0])
+	default:
+		diffs, err = worktree.DiffCommits(repo, args[0], args[1])
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, d := range diffs {
+		fmt.Print(d.Patch())
+	}
+	return nil
+}
+
+// branch lists, creates or deletes branches.
+func branch(repo *got.Repository, args []string) error {
+	flags := flag.NewFlagSet("branch", flag.ContinueOnError)
+	del := flags.Bool("d", false, "delete a branch")
+	list := flags.Bool("list", false, "list branches")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	name := flags.Arg(0)
+
+	switch {
+	case *del:
+		if name == "" {
+			fmt.Println("No branch name provided")
+			return nil
+		}
+		if err := repo.WithLock(func() error { return repo.DeleteBranch(name) }); err != nil {
+			return err
+		}
+		fmt.Println("Branch deleted:", name)
+	case *list || name == "":
+		branches, err := repo.ListBranches()
+		if err != nil {
+			return err
+		}
+		current, err := repo.CurrentBranch()
+		if err != nil {
+			return err
+		}
+		for _, b := range branches {
+			if b == current {
+				fmt.Println("*", b)
+			} else {
+				fmt.Println(" ", b)
+			}
+		}
+	default:
+		rev := flags.Arg(1)
+		if rev == "" {
+			rev = "HEAD"
+		}
+		commitHash, err := object.ResolveCommit(repo, rev)
+		if err != nil {
+			return err
+		}
+		if err := repo.WithLock(func() error { return repo.CreateBranch(name, commitHash) }); err != nil {
+			return err
+		}
+		fmt.Println("Branch created:", name)
+	}
+
+	return nil
+}
+
+// tag lists, creates or deletes tags. Tags are lightweight unless annotated with -a or -m.
+func tag(repo *got.Repository, args []string) error {
+	flags := flag.NewFlagSet("tag", flag.ContinueOnError)
+	del := flags.Bool("d", false, "delete a tag")
+	annotated := flags.Bool("a", false, "make an annotated tag")
+	message := flags.String("m", "", "annotated tag message")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	name := flags.Arg(0)
+
+	switch {
+	case *del:
+		if name == "" {
+			fmt.Println("No tag name provided")
+			return nil
+		}
+		if err := repo.WithLock(func() error { return repo.DeleteTag(name) }); err != nil {
+			return err
+		}
+		fmt.Println("Tag deleted:", name)
+	case name == "":
+		tags, err := repo.ListTags()
+		if err != nil {
+			return err
+		}
+		for _, t := range tags {
+			fmt.Println(t)
+		}
+	default:
+		rev := flags.Arg(1)
+		if rev == "" {
+			rev = "HEAD"
+		}
+		commitHash, err := object.ResolveCommit(repo, rev)
+		if err != nil {
+			return err
+		}
+
+		if (*annotated || *message != "") && *message == "" {
+			fmt.Println("No tag message provided")
+			return nil
+		}
+
+		err = repo.WithLock(func() error {
+			target := commitHash
+			if *message != "" {
+				tagObj, err := object.MakeTag(repo, name, commitHash, *message, time.Now())
+				if err != nil {
+					return err
+				}
+				target = tagObj.HashString
+			}
+			return repo.CreateTag(name, target)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Tag created:", name)
+	}
+
+	return nil
+}
+
+// commit commits staged files, --author makes the commit on behalf of another person.
+func commit(repo *got.Repository, args []string) error {
+	flags := flag.NewFlagSet("commit", flag.ContinueOnError)
+	authorFlag := flags.String("author", "", "commit author in the 'Name <email>' form")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	message := flags.Arg(0)
+	if message == "" {
+		fmt.Println("No commit message provided")
+		return nil
+	}
+
+	var author got.Signature
+	if *authorFlag != "" {
+		var err error
+		if author, err = got.ParseSignature(*authorFlag); err != nil {
+			return err
+		}
+	}
+
+	if err := worktree.MakeCommitAs(repo, message, author, time.Now()); err != nil {
+		return err
+	}
+	head, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Worktree commited:", head)
+	return nil
+}
+
+// gc deletes objects unreachable from refs, or only lists them with --dry-run.
+func gc(repo *got.Repository, args []string) error {
+	config, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	defaultGrace, err := config.Duration("gc.grace", 0)
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only list unreachable objects")
+	grace := flags.Duration("grace", defaultGrace, "keep commits logged within a period, e.g. 720h, defaults to gc.grace")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var garbage []object.StoredObject
+	err = repo.WithLock(func() error {
+		var err error
+		garbage, err = object.GC(repo, object.GCOptions{DryRun: *dryRun, Grace: *grace})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		for _, so := range garbage {
+			fmt.Println("Would remove", so)
+		}
+		fmt.Println("Unreachable objects:", len(garbage))
+		return nil
+	}
+	fmt.Println("Objects removed:", len(garbage))
+	return nil
+}
+
+// setCompression sets or unsets core.compression, which changes the compression of new objects
+// only. Existing objects keep their codecs until they are recompressed by got repack.
+func setCompression(repo *got.Repository, action, value string) error {
+	if repo == nil {
+		return got.ErrNotGotRepo
+	}
+	c := got.DefaultCompression
+	if action == "set" {
+		var err error
+		if c, err = got.ParseCompression(value); err != nil {
+			return fmt.Errorf("config core.compression: %w: %v", got.ErrInvalidConfigValue, err)
+		}
+	}
+	return repo.WithLock(func() error {
+		return repo.SetCompression(c)
+	})
+}
+
+// repack moves all the objects into a single pack. With --compression the repo compression
+// is changed and every object is recompressed.
+func repack(repo *got.Repository, args []string) error {
+	flags := flag.NewFlagSet("repack", flag.ContinueOnError)
+	compression := flags.String("compression", "", "recompress objects with a codec and level, e.g. gzip:9, zstd:3 or none")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	packer, ok := repo.Store.(got.Packer)
+	if !ok {
+		return errors.New("object store does not support packs")
+	}
+
+	var packed int
+	err := repo.WithLock(func() error {
+		if *compression == "" {
+			var err error
+			packed, err = packer.Repack(got.RepackOptions{})
+			return err
+		}
+
+		c, err := got.ParseCompression(*compression)
+		if err != nil {
+			return err
+		}
+		// the config declares a codec before any object is written with it, and drops
+		// other codecs only after every object is recompressed
+		if err := repo.SetCompression(c); err != nil {
+			return err
+		}
+		if packed, err = packer.Repack(got.RepackOptions{Recompress: true}); err != nil {
+			return err
+		}
+		return repo.ForgetOtherCodecs()
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Objects packed:", packed)
+	return nil
+}
+
+// migrate rewrites objects of an older repo into the object headers format.
+func migrate(repo *got.Repository) error {
+	var migrated int
+	err := repo.WithLock(func() error {
+		var err error
+		migrated, err = object.MigrateObjects(repo)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Objects migrated:", migrated)
+	return nil
+}
+
+// fsck prints repo integrity problems. Corrupt or missing objects make it fail, dangling objects
+// and commits pruned by gc are only reported.
+func fsck(repo *got.Repository) error {
+	problems, err := object.Fsck(repo)
+	if err != nil {
+		return err
+	}
+
+	var broken int
+	for _, p := range problems {
+		fmt.Println(p)
+		if p.Kind != object.Dangling && p.Kind != object.Pruned {
+			broken++
+		}
+	}
+	if broken > 0 {
+		return fmt.Errorf("%w: %d corrupt or missing objects", got.ErrRepoCorrupt, broken)
+	}
+	return nil
+}
+
+// repoPaths turns paths relative to the working directory into repo relative ones.
+func repoPaths(repo *got.Repository, cwd string, args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		if !filepath.IsAbs(arg) {
+			arg = filepath.Join(cwd, arg)
+		}
+		p, err := filepath.Rel(repo.Root, arg)
+		if err != nil {
+			return nil, err
+		}
+		if p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s is outside repo: %w", arg, got.ErrNoMatchingPath)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// exitCode maps an error returned by a command to the process exit code.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, got.ErrNotGotRepo):
+		return exitNotRepo
+	case errors.Is(err, got.ErrRepoAlreadyInited), errors.Is(err, got.ErrBranchExists), errors.Is(err, got.ErrTagExists):
+		return exitExists
+	case errors.Is(err, got.ErrObjDoesNotExist), errors.Is(err, got.ErrUnknownRevision), errors.Is(err, got.ErrNoMatchingPath):
+		return exitNoObj
+	case errors.Is(err, got.ErrInvalidObjType), errors.Is(err, got.ErrRepoCorrupt):
+		return exitCorrupt
+	case errors.Is(err, got.ErrRepoLocked):
+		return exitLocked
+	case errors.Is(err, got.ErrLocalChanges):
+		return exitChanges
+	default:
+		return exitFailure
 	}
 }
 
 func printHelpMessage() {
 	fmt.Println(`got init                                        // to init a repo in current dir
-got commit 'initial commit'                     // to commit the state
+got init --object-format=sha256                 // to init a repo naming objects by SHA-256 hashes
+got add app lib/file.go                         // to stage files for the next commit
+got reset lib/file.go                           // to unstage files
+got commit 'initial commit'                     // to commit staged files
+got commit --author 'Ann <ann@example.com>' fix // to commit on behalf of another author (user.name and user.email in .got/config or ~/.gotconfig, or GOT_AUTHOR_NAME and GOT_AUTHOR_EMAIL set the default one)
 got log                                         // to see commits list
-got to d143528ac209d5d927e485e0f923758a21d0901e // to restore a commit
-got current                                     // to see current head commit hash`)
+got check-ignore build/app.o                    // to see which .gotignore rule matches a path
+got status                                      // to see worktree changes (--porcelain for scripts)
+got diff                                        // to see worktree changes against HEAD or a given commit
+got diff v1.2 master                            // to see changes between two commits
+got to d143528ac209d5d927e485e0f923758a21d0901e // to restore a commit (--force to discard local changes)
+got to feature                                  // to switch to a branch
+got branch feature                              // to create a branch at current commit
+got branch -d feature                           // to delete a branch
+got branch --list                               // to see branches list
+got tag v1.2 d143528ac209d5d927e485e0f923758a21d0901e // to tag a commit
+got tag -m 'release 1.2' v1.2                   // to make an annotated tag at current commit
+got tag -d v1.2                                 // to delete a tag
+got show v1.2                                   // to see a tag or an object contents
+got current                                     // to see current head commit hash
+got gc                                          // to delete objects unreachable from refs and pack the rest (--grace 720h keeps recently logged commits)
+got gc --dry-run                                // to list objects gc would delete
+got fsck                                        // to verify objects and refs integrity
+got repack                                      // to pack loose objects into a single file
+got repack --compression=zstd:3                 // to recompress all the objects with a codec (gzip:1-9, zstd:1-22, none)
+got config set core.compression zstd:3          // to compress new objects only with a codec, older ones keep theirs until repacked
+got migrate                                     // to move object metadata from archive headers into object headers
+got config set user.email ann@example.com       // to set a value in the repo config (--user for ~/.gotconfig, --system for /etc/gotconfig)
+got config get user.email                       // to see a value, repo values override user ones and user values override system ones
+got config unset user.email                     // to remove a value
+got config list                                 // to see values of all the config files (core.workers, core.excludesfile, gc.grace, user.name, user.email)
+got -jobs 4 commit 'message'                    // to limit goroutines hashing and writing objects`)
 }
 
 func blankRepoCommand(command string) bool {
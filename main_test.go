@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -21,20 +22,22 @@ import (
 
 var expectedHashSums map[string]string = map[string]string{
 	"initial state":                  "e3980c53eecf817099d9eed5202e33d50a84a903",
-	"repo initiated":                 "ff03475a2b21e13c2ad33881a5171f2aeb8f84a2",
-	"after initial commit":           "898fbc176b8d57afa9653aba855e8e76cb361c70",
-	"after first change":             "ec802cd71985e34ab3b7f5eede16c9446802e460",
-	"after second change":            "0d5db35ed364e7c9421cb41f92ec654ba4bf2e71",
-	"after checkout to first change": "d64552265c66baedad2560c5fe5c52b7f9225227",
+	"repo initiated":                 "e682715e27e075ba10fca469abd7846e615efcdd",
+	"after initial commit":           "033f5a997e1a088d830e2dfa074a1b98c34e5e75",
+	"after first change":             "8776a261a52387887497bb2e6d9dea79c7a69c64",
+	"after second change":            "c929d6fd62627cb90bc58e1426d61be7b8b99abe",
+	"after checkout to first change": "c0f484901b542eb6bc234d047e25d8693eb14ed4",
 }
 
-var commitToCheckout = "78bb45636d49ed0e1a6a9a2a54aa7a0d6eb18173"
+var commitToCheckout = "97d888abd2fdd81dc29c1356c0482919a293cb43"
 
-var expectedShowLen = 112
+var expectedShowLen = 337
 var expectedLogLen = 405
 
 var dummyAppPath string
 
+var repo *got.Repository
+
 func TestMain(m *testing.M) {
 	curDir, err := os.Getwd()
 	if err != nil {
@@ -43,6 +46,14 @@ func TestMain(m *testing.M) {
 	dummyAppPath = path.Join(curDir, "test/dummy_app")
 	os.Chdir(dummyAppPath)
 
+	// commit contents shown include the identity, it should not depend on the user running tests
+	for _, env := range []string{got.EnvAuthorName, got.EnvCommitterName} {
+		os.Setenv(env, "Got Tester")
+	}
+	for _, env := range []string{got.EnvAuthorEmail, got.EnvCommitterEmail} {
+		os.Setenv(env, "tester@example.com")
+	}
+
 	misc.CreateDummyApp()
 	exitCode := m.Run()
 	misc.RemoveDummyApp()
@@ -52,45 +63,76 @@ func TestMain(m *testing.M) {
 func TestMainWorkflow(t *testing.T) {
 	checkRepoSum(t, "initial state")
 
-	got.InitRepo()
-	got.SetRepoRoot()
+	var err error
+	if repo, err = got.Init(dummyAppPath); err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
 
 	checkRepoSum(t, "repo initiated")
 
-	worktree.MakeCommit("initial commit", time.Now())
+	// commit hashes cover commit times, fixed times keep them the same on every run
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	makeCommit(t, "initial commit", start)
 
 	checkRepoSum(t, "after initial commit")
 
 	makeFirstChange()
-	worktree.MakeCommit("first change", time.Now().AddDate(0, 0, 1))
+	makeCommit(t, "first change", start.AddDate(0, 0, 1))
 
 	checkRepoSum(t, "after first change")
 
 	makeSecondChange()
-	worktree.MakeCommit("second change", time.Now().AddDate(0, 0, 2))
+	makeCommit(t, "second change", start.AddDate(0, 0, 2))
 
 	checkRepoSum(t, "after second change")
 
-	worktree.ToCommit(commitToCheckout)
+	if err := worktree.ToCommit(repo, commitToCheckout, false); err != nil {
+		t.Fatalf("checkout to %v: %v", commitToCheckout, err)
+	}
 
 	checkRepoSum(t, "after checkout to first change")
 
-	head := got.ReadHead()
+	head, err := repo.ReadHead()
+	if err != nil {
+		t.Fatalf("read head: %v", err)
+	}
 	if head != commitToCheckout {
 		t.Fatalf("expected head be on %v, got %v", commitToCheckout, head)
 	}
 
-	commitInfo := object.Show(commitToCheckout)
+	commitInfo, err := object.Show(repo, commitToCheckout)
+	if err != nil {
+		t.Fatalf("show commit: %v", err)
+	}
 	if len(commitInfo) != expectedShowLen {
 		t.Fatalf("expected to have %v bytes of commit contents, got %v", expectedShowLen, len(commitInfo))
 	}
 
-	logs := got.ReadLog()
+	logs, err := repo.ReadLog()
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
 	if len(logs) != expectedLogLen {
 		t.Fatalf("expected to have %v bytes of logs, got %v", expectedLogLen, len(logs))
 	}
 }
 
+func TestShowMissingObject(t *testing.T) {
+	_, err := object.Show(repo, "0000000000000000000000000000000000000001")
+	if !errors.Is(err, got.ErrObjDoesNotExist) {
+		t.Fatalf("expected %v, got %v", got.ErrObjDoesNotExist, err)
+	}
+}
+
+func makeCommit(t *testing.T, message string, tm time.Time) {
+	if err := worktree.Add(repo, []string{"."}); err != nil {
+		t.Fatalf("add worktree: %v", err)
+	}
+	if err := worktree.MakeCommit(repo, message, tm); err != nil {
+		t.Fatalf("commit %q: %v", message, err)
+	}
+}
+
 func checkRepoSum(t *testing.T, step string) {
 	sum := repoStateHashSum()
 	if sum != expectedHashSums[step] {
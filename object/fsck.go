@@ -0,0 +1,232 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/shved/got/got"
+)
+
+// FsckKind is a kind of a repo integrity problem.
+type FsckKind int
+
+const (
+	// Corrupt objects can not be read or do not match their hashes.
+	Corrupt FsckKind = iota + 1
+	// Missing objects are referenced by other objects or refs but are not stored.
+	Missing
+	// Dangling objects are stored but nothing references them.
+	Dangling
+	// Pruned commits are recorded in LOG entries only and were deleted by gc.
+	Pruned
+)
+
+// String returns a problem kind name.
+func (k FsckKind) String() string {
+	switch k {
+	case Corrupt:
+		return "corrupt"
+	case Missing:
+		return "missing"
+	case Dangling:
+		return "dangling"
+	case Pruned:
+		return "pruned"
+	default:
+		return "unknown"
+	}
+}
+
+// FsckProblem is an object integrity problem found by Fsck.
+type FsckProblem struct {
+	Kind   FsckKind
+	Object StoredObject
+	Detail string
+}
+
+// String returns a problem in a form of "kind type hash: detail".
+func (p FsckProblem) String() string {
+	s := p.Kind.String() + " " + p.Object.String()
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	return s
+}
+
+// Fsck reads every stored object and checks its content matches its hash, that objects referenced
+// by commits, trees, tags, refs, the index and LOG entries are stored, and which objects are not
+// referenced at all. Commits only LOG entries point to are not required, gc deletes them, so those
+// which are gone are reported as pruned. Problems are returned in the order objects are checked.
+func Fsck(repo *got.Repository) ([]FsckProblem, error) {
+	var problems []FsckProblem
+	var stored []StoredObject
+	referenced := make(map[StoredObject]bool)
+	// references are checked after all the objects are read, every one keeps its first referrer
+	var refs []StoredObject
+	referrers := make(map[StoredObject]string)
+	reference := func(so StoredObject, by string) {
+		if !referenced[so] {
+			referenced[so] = true
+			refs = append(refs, so)
+			referrers[so] = by
+		}
+	}
+
+	for _, t := range []ObjectType{Commit, Tree, Blob, Tag} {
+		err := repo.Store.Iterate(t.toString(), func(hash string) error {
+			so := StoredObject{Type: t, Hash: hash}
+			stored = append(stored, so)
+
+			h, body, err := readObject(repo, t, hash)
+			if err != nil {
+				problems = append(problems, FsckProblem{Kind: Corrupt, Object: so, Detail: err.Error()})
+				return nil
+			}
+			// objects are hashed over their body only, the same way they are made, commits are
+			// hashed along with their header unless they were made before it was hashed
+			check := &Object{ObjType: t}
+			check.writeShaSum(repo, body)
+			if t == Commit && check.HashString != hash {
+				if data, err := got.EncodeObject(h, body); err == nil {
+					check.writeShaSum(repo, data)
+				}
+			}
+			if check.HashString != hash {
+				problems = append(problems, FsckProblem{Kind: Corrupt, Object: so, Detail: "content hash is " + check.HashString})
+				return nil
+			}
+			if t == Blob {
+				return nil
+			}
+			entries, err := parseObjContent(string(body))
+			if err != nil {
+				problems = append(problems, FsckProblem{Kind: Corrupt, Object: so, Detail: err.Error()})
+				return nil
+			}
+			for _, e := range entries {
+				reference(StoredObject{Type: e.t, Hash: e.hashString}, "referenced by "+so.String())
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("fsck: %w", err)
+		}
+	}
+
+	var logged []StoredObject
+	logReference := func(so StoredObject, by string) {
+		if !referenced[so] {
+			referenced[so] = true
+			logged = append(logged, so)
+			referrers[so] = by
+		}
+	}
+
+	if err := fsckRefs(repo, reference, logReference); err != nil {
+		return nil, fmt.Errorf("fsck: %w", err)
+	}
+
+	for _, so := range refs {
+		ok, err := repo.Store.Has(so.Type.toString(), so.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("fsck: %w", err)
+		}
+		if !ok {
+			problems = append(problems, FsckProblem{Kind: Missing, Object: so, Detail: referrers[so]})
+		}
+	}
+
+	for _, so := range logged {
+		ok, err := repo.Store.Has(so.Type.toString(), so.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("fsck: %w", err)
+		}
+		if !ok {
+			problems = append(problems, FsckProblem{Kind: Pruned, Object: so, Detail: referrers[so]})
+		}
+	}
+
+	for _, so := range stored {
+		if !referenced[so] {
+			problems = append(problems, FsckProblem{Kind: Dangling, Object: so})
+		}
+	}
+
+	return problems, nil
+}
+
+// fsckRefs references objects HEAD, branches, tags and index entries point to. Commits of LOG
+// entries go last through logReference, so commits referenced otherwise stay required.
+func fsckRefs(repo *got.Repository, reference, logReference func(so StoredObject, by string)) error {
+	commit := func(hash, by string) {
+		if hash != "" && hash != repo.EmptyRef() {
+			reference(StoredObject{Type: Commit, Hash: hash}, by)
+		}
+	}
+
+	head, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	commit(head, "HEAD")
+
+	branches, err := repo.ListBranches()
+	if err != nil {
+		return err
+	}
+	for _, name := range branches {
+		hash, err := repo.ReadBranch(name)
+		if err != nil {
+			return err
+		}
+		commit(hash, "branch "+name)
+	}
+
+	tags, err := repo.ListTags()
+	if err != nil {
+		return err
+	}
+	for _, name := range tags {
+		hash, err := repo.ReadTag(name)
+		if err != nil {
+			return err
+		}
+		isTagObj, err := repo.Store.Has(Tag.toString(), hash)
+		if err != nil {
+			return err
+		}
+		if isTagObj {
+			reference(StoredObject{Type: Tag, Hash: hash}, "tag "+name)
+		} else {
+			commit(hash, "tag "+name)
+		}
+	}
+
+	idx, err := repo.ReadIndex()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if idx != nil {
+		for _, e := range idx.Entries() {
+			reference(StoredObject{Type: Blob, Hash: e.Hash}, "index entry "+filepath.ToSlash(e.Path))
+		}
+	}
+
+	entries, err := repo.ReadLogEntries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		by := "LOG entry " + e.Time.UTC().Format(time.RFC3339)
+		for _, hash := range []string{e.Hash, e.ParentHash} {
+			if hash != "" && hash != repo.EmptyRef() {
+				logReference(StoredObject{Type: Commit, Hash: hash}, by)
+			}
+		}
+	}
+
+	return nil
+}
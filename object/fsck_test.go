@@ -0,0 +1,50 @@
+package object
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shved/got/got"
+)
+
+func TestFsck(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	head := writeCommit(t, repo, repo.EmptyRef(), "first", map[string]string{"a.txt": "one", "b.txt": "two"})
+	if err := repo.AdvanceHead(head); err != nil {
+		t.Fatalf("advance head: %v", err)
+	}
+
+	problems, err := Fsck(repo)
+	if err != nil {
+		t.Fatalf("fsck: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems in a fresh repo, got %v", problems)
+	}
+
+	blob := blobHash(repo, "one")
+	if err := repo.Store.Delete("blob", blob); err != nil {
+		t.Fatalf("delete blob: %v", err)
+	}
+	if err := repo.Store.Put("blob", "0000000000000000000000000000000000000001", &got.RawObject{Data: []byte("junk")}); err != nil {
+		t.Fatalf("put blob: %v", err)
+	}
+
+	problems, err = Fsck(repo)
+	if err != nil {
+		t.Fatalf("fsck: %v", err)
+	}
+	var kinds []FsckKind
+	for _, p := range problems {
+		kinds = append(kinds, p.Kind)
+	}
+	if !reflect.DeepEqual(kinds, []FsckKind{Corrupt, Missing, Dangling}) {
+		t.Fatalf("expected corrupt, missing and dangling objects, got %v", problems)
+	}
+	if problems[1].Object.Hash != blob || !strings.Contains(problems[1].Detail, head) {
+		t.Fatalf("expected blob %s referenced by commit %s to be missing, got %v", blob, head, problems[1])
+	}
+}
@@ -0,0 +1,192 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shved/got/got"
+)
+
+// StoredObject is an object address in the object store.
+type StoredObject struct {
+	Type ObjectType
+	Hash string
+}
+
+// String returns an object type name followed by the object hash.
+func (so StoredObject) String() string {
+	return so.Type.toString() + " " + so.Hash
+}
+
+// GCOptions tune garbage collection.
+type GCOptions struct {
+	// DryRun only reports unreachable objects without deleting them.
+	DryRun bool
+	// Grace keeps commits logged within the period before Now along with everything they reach.
+	// Zero grace keeps only objects reachable from refs and the index.
+	Grace time.Duration
+	// Now is a time the grace period is counted back from, zero means the current time.
+	Now time.Time
+}
+
+// GC deletes commit, tree, blob and tag objects which are not reachable from HEAD, branches, tags,
+// the index or commits logged within the grace period, and returns them sorted by type and hash.
+// Stores able to pack objects get the rest of the objects packed. The caller holds the repo lock.
+func GC(repo *got.Repository, opts GCOptions) ([]StoredObject, error) {
+	roots, err := gcRoots(repo, opts)
+	if err != nil {
+		return nil, fmt.Errorf("gc: %w", err)
+	}
+	reachable, err := Reachable(repo, roots)
+	if err != nil {
+		return nil, fmt.Errorf("gc: %w", err)
+	}
+
+	var garbage []StoredObject
+	for _, t := range []ObjectType{Commit, Tree, Blob, Tag} {
+		err := repo.Store.Iterate(t.toString(), func(hash string) error {
+			so := StoredObject{Type: t, Hash: hash}
+			if !reachable[so] {
+				garbage = append(garbage, so)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("gc: %w", err)
+		}
+	}
+
+	if opts.DryRun {
+		return garbage, nil
+	}
+
+	// packing stores get reachable objects packed, which drops the garbage along the way
+	if packer, ok := repo.Store.(got.Packer); ok {
+		_, err := packer.Repack(got.RepackOptions{Keep: func(objType, hash string) bool {
+			t, err := strToObjType(objType)
+			return err != nil || reachable[StoredObject{Type: t, Hash: hash}]
+		}})
+		if err != nil {
+			return nil, fmt.Errorf("gc: %w", err)
+		}
+		return garbage, nil
+	}
+	for _, so := range garbage {
+		if err := repo.Store.Delete(so.Type.toString(), so.Hash); err != nil {
+			return nil, fmt.Errorf("gc: %w", err)
+		}
+	}
+	return garbage, nil
+}
+
+// gcRoots collects objects garbage collection starts marking from.
+func gcRoots(repo *got.Repository, opts GCOptions) ([]StoredObject, error) {
+	var roots []StoredObject
+	addCommit := func(hash string) {
+		if hash != repo.EmptyRef() && hash != "" {
+			roots = append(roots, StoredObject{Type: Commit, Hash: hash})
+		}
+	}
+
+	head, err := repo.ReadHead()
+	if err != nil {
+		return nil, err
+	}
+	addCommit(head)
+
+	branches, err := repo.ListBranches()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range branches {
+		hash, err := repo.ReadBranch(name)
+		if err != nil {
+			return nil, err
+		}
+		addCommit(hash)
+	}
+
+	tags, err := repo.ListTags()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range tags {
+		hash, err := repo.ReadTag(name)
+		if err != nil {
+			return nil, err
+		}
+		// lightweight tags point to commits, annotated ones to tag objects
+		isTagObj, err := repo.Store.Has(Tag.toString(), hash)
+		if err != nil {
+			return nil, err
+		}
+		if isTagObj {
+			roots = append(roots, StoredObject{Type: Tag, Hash: hash})
+		} else {
+			addCommit(hash)
+		}
+	}
+
+	idx, err := repo.ReadIndex()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if idx != nil {
+		for _, e := range idx.Entries() {
+			roots = append(roots, StoredObject{Type: Blob, Hash: e.Hash})
+		}
+	}
+
+	if opts.Grace > 0 {
+		now := opts.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		entries, err := repo.ReadLogEntries()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Time.After(now.Add(-opts.Grace)) {
+				addCommit(e.Hash)
+			}
+		}
+	}
+
+	return roots, nil
+}
+
+// Reachable marks objects reachable from given roots through tag targets, parent commits and
+// tree entries. Blobs are not read, commits, trees and tags which are missing make it fail.
+func Reachable(repo *got.Repository, roots []StoredObject) (map[StoredObject]bool, error) {
+	reachable := make(map[StoredObject]bool)
+	stack := append([]StoredObject(nil), roots...)
+
+	for len(stack) > 0 {
+		so := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if reachable[so] {
+			continue
+		}
+		reachable[so] = true
+		if so.Type == Blob {
+			continue
+		}
+
+		_, body, err := readObject(repo, so.Type, so.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("mark %s: %w", so, err)
+		}
+		entries, err := parseObjContent(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("mark %s: %w", so, err)
+		}
+		for _, e := range entries {
+			stack = append(stack, StoredObject{Type: e.t, Hash: e.hashString})
+		}
+	}
+
+	return reachable, nil
+}
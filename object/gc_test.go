@@ -0,0 +1,62 @@
+package object
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shved/got/got"
+)
+
+func TestGC(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	first := writeCommit(t, repo, repo.EmptyRef(), "first", map[string]string{"a.txt": "one"})
+	second := writeCommit(t, repo, first, "second", map[string]string{"a.txt": "two"})
+	if err := repo.AdvanceHead(second); err != nil {
+		t.Fatalf("advance head: %v", err)
+	}
+	// a commit made on a detached HEAD is left hanging after switching back to master
+	hanging := writeCommit(t, repo, first, "hanging", map[string]string{"a.txt": "three"})
+
+	staged := blobHash(repo, "staged")
+	if err := putObject(repo, Blob, staged, got.ObjectHeader{Name: "staged.txt", Time: time.Now()}, []byte("staged")); err != nil {
+		t.Fatalf("put blob: %v", err)
+	}
+	idx := got.NewIndex()
+	idx.Add(got.IndexEntry{Path: "staged.txt", Mode: 0644, Size: 6, ModTime: time.Now(), Hash: staged})
+	if err := repo.WriteIndex(idx); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+
+	kept, err := GC(repo, GCOptions{DryRun: true, Grace: time.Hour})
+	if err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if len(kept) != 0 {
+		t.Fatalf("expected recently logged commits to be kept, got %v", kept)
+	}
+
+	garbage, err := GC(repo, GCOptions{})
+	if err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	expected := []StoredObject{{Type: Commit, Hash: hanging}, {Type: Blob, Hash: blobHash(repo, "three")}}
+	if !reflect.DeepEqual(garbage, expected) {
+		t.Fatalf("expected hanging commit and its blob to be collected, got %v", garbage)
+	}
+	if ok, _ := repo.Store.Has("commit", hanging); ok {
+		t.Fatal("expected hanging commit to be deleted")
+	}
+	if ok, _ := repo.Store.Has("commit", first); !ok {
+		t.Fatal("expected reachable commit to be kept")
+	}
+	if ok, _ := repo.Store.Has("blob", staged); !ok {
+		t.Fatal("expected staged blob to be kept")
+	}
+	problems, err := Fsck(repo)
+	if err != nil || len(problems) != 1 || problems[0].Kind != Pruned || problems[0].Object.Hash != hanging {
+		t.Fatalf("expected only the collected commit to be reported as pruned, got %v, %v", problems, err)
+	}
+}
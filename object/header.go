@@ -0,0 +1,88 @@
+package object
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shved/got/got"
+)
+
+// readObject reads a stored object and splits it into a header and a body. Objects of repos
+// not migrated to object headers get their header built from archive header fields.
+func readObject(repo *got.Repository, t ObjectType, hash string) (got.ObjectHeader, []byte, error) {
+	raw, err := repo.Store.Get(t.toString(), hash)
+	if err != nil {
+		return got.ObjectHeader{}, nil, err
+	}
+	if !repo.ObjectHeaders {
+		return legacyHeader(t, raw), raw.Data, nil
+	}
+
+	h, body, err := got.DecodeObject(raw.Data)
+	if err != nil {
+		return got.ObjectHeader{}, nil, fmt.Errorf("read %s %s: %w", t.toString(), hash, err)
+	}
+	if h.Type != t.toString() {
+		return got.ObjectHeader{}, nil, fmt.Errorf("read %s %s: header type is %s: %w", t.toString(), hash, h.Type, got.ErrInvalidObjType)
+	}
+	return h, body, nil
+}
+
+// putObject stores an object body along with its header. Repos not migrated to object headers
+// get the metadata written into archive header fields, which keep a name, a message and a time.
+// Those have no room for authors, so objects signed by someone are refused there.
+func putObject(repo *got.Repository, t ObjectType, hash string, h got.ObjectHeader, body []byte) error {
+	h.Type = t.toString()
+	if !repo.ObjectHeaders {
+		for _, sig := range []got.Signature{h.Author, h.Committer, h.Tagger} {
+			if signaturePerson(sig) != "" {
+				return fmt.Errorf("write %s %s: %w: signatures need object headers, run got migrate", h.Type, hash, got.ErrUnsupportedFormat)
+			}
+		}
+		raw := &got.RawObject{Name: h.Name, Comment: h.Message, ModTime: headerTime(h), Data: body}
+		return repo.Store.Put(h.Type, hash, raw)
+	}
+
+	data, err := got.EncodeObject(h, body)
+	if err != nil {
+		return err
+	}
+	return repo.Store.Put(h.Type, hash, &got.RawObject{Data: data})
+}
+
+// legacyHeader builds a header of an object kept with its metadata in archive header fields.
+// Commit parents are taken from the commit content, signatures have the time only.
+func legacyHeader(t ObjectType, raw *got.RawObject) got.ObjectHeader {
+	h := got.ObjectHeader{Type: t.toString(), Size: len(raw.Data), Name: raw.Name}
+	switch t {
+	case Commit:
+		h.Message = raw.Comment
+		h.Author = got.Signature{When: raw.ModTime}
+		h.Committer = h.Author
+		// content is checked when it is read as an object graph
+		entries, _ := parseObjContent(string(raw.Data))
+		for _, e := range entries {
+			if e.t == Commit {
+				h.Parents = append(h.Parents, e.hashString)
+			}
+		}
+	case Tag:
+		h.Message = raw.Comment
+		h.Tagger = got.Signature{When: raw.ModTime}
+	default:
+		h.Time = raw.ModTime
+	}
+	return h
+}
+
+// headerTime returns the time an object was made at.
+func headerTime(h got.ObjectHeader) time.Time {
+	switch {
+	case !h.Committer.When.IsZero():
+		return h.Committer.When
+	case !h.Tagger.When.IsZero():
+		return h.Tagger.When
+	default:
+		return h.Time
+	}
+}
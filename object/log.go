@@ -0,0 +1,36 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shved/got/got"
+)
+
+const logHeader = "Time\t\t\tCommit hash\t\t\t\t\tParent hash\t\t\t\t\tAuthor\t\t\tCommit message"
+
+// Log returns LOG entries from the newest to the oldest along with authors of logged commits.
+// Commits deleted by gc and commits made before authors were recorded have no author shown.
+func Log(repo *got.Repository) (string, error) {
+	entries, err := repo.ReadLogEntries()
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{logHeader}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		var author string
+		h, _, err := readObject(repo, Commit, e.Hash)
+		switch {
+		case err == nil:
+			author = signaturePerson(h.Author)
+		case !errors.Is(err, got.ErrObjDoesNotExist):
+			return "", fmt.Errorf("log: %w", err)
+		}
+		lines = append(lines, strings.Join([]string{e.Time.UTC().Format(time.RFC3339), e.Hash, e.ParentHash, author, e.Message}, "\t"))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,51 @@
+package object
+
+import (
+	"fmt"
+
+	"github.com/shved/got/got"
+)
+
+// MigrateObjects rewrites objects of a repo made before object headers were introduced, moving
+// their metadata from archive header fields into object headers, and records the new format in
+// the repo config. Object bodies are kept as they are, so object names, refs and the index do not
+// change. Every object is read and rewritten before anything is written, the results are staged
+// by the store and published only after the new format is recorded, so the repo never mixes
+// migrated and older objects. It returns a number of rewritten objects.
+func MigrateObjects(repo *got.Repository) (int, error) {
+	if repo.ObjectHeaders {
+		return 0, nil
+	}
+	rw, ok := repo.Store.(got.Rewriter)
+	if !ok {
+		return 0, fmt.Errorf("migrate objects: %w: the object store could not rewrite objects", got.ErrUnsupportedFormat)
+	}
+
+	var migrated int
+	_, err := rw.StageRewrite(func(objType, hash string, raw *got.RawObject) (*got.RawObject, error) {
+		t, err := strToObjType(objType)
+		if err != nil {
+			return nil, fmt.Errorf("migrate %s %s: %w", objType, hash, err)
+		}
+		if h, _, err := got.DecodeObject(raw.Data); err == nil && h.Type == objType {
+			return raw, nil
+		}
+		data, err := got.EncodeObject(legacyHeader(t, raw), raw.Data)
+		if err != nil {
+			return nil, fmt.Errorf("migrate %s %s: %w", objType, hash, err)
+		}
+		migrated++
+		return &got.RawObject{Data: data}, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("migrate objects: %w", err)
+	}
+
+	if err := repo.EnableObjectHeaders(); err != nil {
+		return 0, fmt.Errorf("migrate objects: %w", err)
+	}
+	if err := rw.PublishStaged(); err != nil {
+		return migrated, fmt.Errorf("migrate objects: %w", err)
+	}
+	return migrated, nil
+}
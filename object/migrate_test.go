@@ -0,0 +1,158 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shved/got/got"
+)
+
+// legacyCommit commits lib/a.txt the way got did before object headers, with metadata in
+// archive header fields and no authors. It returns the commit and the blob hashes.
+func legacyCommit(t *testing.T, repo *got.Repository, message, content string, when time.Time) (string, string) {
+	put := func(typ, name, comment, body string) string {
+		hash := fmt.Sprintf("%x", repo.Hash.Sum([]byte(body)))
+		if err := repo.Store.Put(typ, hash, &got.RawObject{Name: name, Comment: comment, ModTime: when, Data: []byte(body)}); err != nil {
+			t.Fatalf("put %s: %v", typ, err)
+		}
+		return hash
+	}
+
+	blob := put("blob", "a.txt", "", content)
+	tree := put("tree", "lib", "", "blob\t"+blob+"\ta.txt\t100644")
+	lines := []string{"tree\t" + tree + "\tlib\t040000"}
+	parent, err := repo.ReadHead()
+	if err != nil {
+		t.Fatalf("read head: %v", err)
+	}
+	if parent != repo.EmptyRef() {
+		raw, err := repo.Store.Get("commit", parent)
+		if err != nil {
+			t.Fatalf("get parent commit: %v", err)
+		}
+		lines = append([]string{"commit\t" + parent + "\t" + raw.Comment}, lines...)
+	}
+	commit := put("commit", "", message, strings.Join(lines, "\n"))
+
+	if err := repo.UpdateLog(strings.Join([]string{when.UTC().Format(time.RFC3339), commit, parent, message}, "\t") + "\n"); err != nil {
+		t.Fatalf("update log: %v", err)
+	}
+	if err := repo.AdvanceHead(commit); err != nil {
+		t.Fatalf("advance head: %v", err)
+	}
+	return commit, blob
+}
+
+func TestMigrateObjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	repo, err := got.Init(dir)
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	// repos made before object headers have no extensions
+	if err := ioutil.WriteFile(repo.ConfigPath(), []byte("[core]\n\trepositoryformatversion = 0\n"), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if repo, err = got.Open(dir); err != nil || repo.ObjectHeaders {
+		t.Fatalf("expected a repo without object headers, got %v", err)
+	}
+
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprintf("key_%d: %x", i, i*7907))
+	}
+	var contents, blobs []string
+	var first, second string
+	start := time.Unix(1577836800, 0)
+	for v, message := range []string{"first", "second", "third"} {
+		lines[v*100] = fmt.Sprintf("key_%d: version %d", v*100, v)
+		contents = append(contents, strings.Join(lines, "\n"))
+		commit, blob := legacyCommit(t, repo, message, contents[v], start.Add(time.Duration(v)*time.Minute))
+		blobs = append(blobs, blob)
+		switch v {
+		case 0:
+			first = commit
+		case 1:
+			second = commit
+		}
+	}
+	if _, err := MakeTag(repo, "v2", second, "release", start); !errors.Is(err, got.ErrUnsupportedFormat) {
+		t.Fatalf("expected a tag to need object headers, got %v", err)
+	}
+	tagBody := "commit\t" + first + "\tv1"
+	tag := fmt.Sprintf("%x", repo.Hash.Sum([]byte(tagBody)))
+	if err := repo.Store.Put("tag", tag, &got.RawObject{Name: "v1", Comment: "release", ModTime: start, Data: []byte(tagBody)}); err != nil {
+		t.Fatalf("put tag: %v", err)
+	}
+	if err := repo.CreateTag("v1", tag); err != nil {
+		t.Fatalf("create tag: %v", err)
+	}
+	if _, err := repo.Store.(got.Packer).Repack(got.RepackOptions{}); err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+	idx, err := filepath.Glob(filepath.Join(dir, ".got", "objects", "pack", "*.idx"))
+	if err != nil || len(idx) != 1 {
+		t.Fatalf("expected a single pack, got %v, %v", idx, err)
+	}
+	if data, _ := ioutil.ReadFile(idx[0]); !strings.Contains(string(data), "\t"+blobs[0]+"\n") {
+		t.Fatalf("expected later blob versions to be delta encoded against the first one, got\n%s", data)
+	}
+
+	migrated, err := MigrateObjects(repo)
+	if err != nil {
+		t.Fatalf("migrate objects: %v", err)
+	}
+	if migrated != 10 {
+		t.Fatalf("expected 3 commits, 3 trees, 3 blobs and a tag to be migrated, got %d", migrated)
+	}
+	if repo, err = got.Open(dir); err != nil || !repo.ObjectHeaders {
+		t.Fatalf("expected the repo to record object headers, got %v", err)
+	}
+	if migrated, err = MigrateObjects(repo); err != nil || migrated != 0 {
+		t.Fatalf("expected nothing to migrate twice, got %d, %v", migrated, err)
+	}
+
+	for v, blob := range blobs {
+		h, body, err := readObject(repo, Blob, blob)
+		if err != nil {
+			t.Fatalf("read blob %d: %v", v, err)
+		}
+		if string(body) != contents[v] || h.Name != "a.txt" {
+			t.Fatalf("expected blob %d to keep its name and content, got %+v", v, h)
+		}
+	}
+	h, _, err := readObject(repo, Commit, second)
+	if err != nil {
+		t.Fatalf("read commit: %v", err)
+	}
+	if h.Message != "second" || !reflect.DeepEqual(h.Parents, []string{first}) {
+		t.Fatalf("expected commit header to keep message and parent, got %+v", h)
+	}
+	if commit, err := ResolveCommit(repo, "v1"); err != nil || commit != first {
+		t.Fatalf("expected tag to resolve into %s, got %s, %v", first, commit, err)
+	}
+	problems, err := Fsck(repo)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no fsck problems, got %v, %v", problems, err)
+	}
+
+	// metadata is not limited by archive header fields anymore
+	note := got.ObjectHeader{Name: "ß.txt", Time: start}
+	if err := putObject(repo, Blob, "0000000000000000000000000000000000000001", note, []byte("third")); err != nil {
+		t.Fatalf("put blob: %v", err)
+	}
+	if h, _, err := readObject(repo, Blob, "0000000000000000000000000000000000000001"); err != nil || h.Name != "ß.txt" {
+		t.Fatalf("expected blob name to be kept, got %+v, %v", h, err)
+	}
+}
@@ -0,0 +1,59 @@
+package object
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// FileMode is a mode of a tree entry.
+type FileMode uint32
+
+const (
+	ModeRegular    FileMode = 0100644
+	ModeExecutable FileMode = 0100755
+	ModeSymlink    FileMode = 0120000
+	ModeDir        FileMode = 040000
+)
+
+// ModeFromOS converts file system mode into a tree entry mode.
+func ModeFromOS(m os.FileMode) FileMode {
+	switch {
+	case m&os.ModeSymlink != 0:
+		return ModeSymlink
+	case m.IsDir():
+		return ModeDir
+	case m.Perm()&0111 != 0:
+		return ModeExecutable
+	default:
+		return ModeRegular
+	}
+}
+
+// OSMode converts a tree entry mode into file system mode.
+func (m FileMode) OSMode() os.FileMode {
+	switch m {
+	case ModeSymlink:
+		return os.ModeSymlink | 0777
+	case ModeDir:
+		return os.ModeDir | 0755
+	case ModeExecutable:
+		return 0755
+	default:
+		return 0644
+	}
+}
+
+// String returns an octal mode representation used in tree entries.
+func (m FileMode) String() string {
+	return fmt.Sprintf("%06o", uint32(m))
+}
+
+// parseMode parses an octal tree entry mode.
+func parseMode(s string) (FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q: %w", s, err)
+	}
+	return FileMode(m), nil
+}
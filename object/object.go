@@ -2,13 +2,11 @@
 package object
 
 import (
-	"compress/gzip"
-	"crypto/sha1"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -22,11 +20,13 @@ const (
 	Commit ObjectType = iota + 1
 	Tree
 	Blob
+	Tag
 )
 
 // Object is a struct representation of a repo object.
 type Object struct {
 	ObjType          ObjectType
+	Mode             FileMode
 	Parent           *Object
 	Children         []*Object
 	Name             string
@@ -34,119 +34,276 @@ type Object struct {
 	Path             string
 	ParentCommitHash string
 	CommitMessage    string
+	TargetHash       string
 	HashString       string
 	Timestamp        time.Time
+	// Author is a commit author or a tagger, Committer is set for commits only.
+	Author    got.Signature
+	Committer got.Signature
 
 	sha          []byte
 	contentLines []string
 	gzipContent  string
 }
 
-// Show returns a string with object content.
-func Show(shaString string) string {
-	if exists(path.Join(got.CommitDirAbsPath(), shaString)) {
-		return objContent(path.Join(got.CommitDirAbsPath(), shaString))
+// Show returns a string with object content. Branch and tag names are resolved into objects
+// they point to, annotated tags are shown along with the tagged commit.
+func Show(repo *got.Repository, rev string) (string, error) {
+	shaString, err := repo.ReadRef(rev)
+	if err != nil {
+		return "", fmt.Errorf("show %s: %w", rev, err)
+	}
+
+	isTag, err := repo.Store.Has(Tag.toString(), shaString)
+	if err != nil {
+		return "", fmt.Errorf("show %s: %w", rev, err)
+	}
+	if isTag {
+		return showTag(repo, shaString)
+	}
+	isCommit, err := repo.Store.Has(Commit.toString(), shaString)
+	if err != nil {
+		return "", fmt.Errorf("show %s: %w", rev, err)
+	}
+	if isCommit {
+		return showCommit(repo, shaString)
+	}
+
+	for _, t := range []ObjectType{Tree, Blob} {
+		ok, err := repo.Store.Has(t.toString(), shaString)
+		if err != nil {
+			return "", fmt.Errorf("show %s: %w", shaString, err)
+		}
+		if !ok {
+			continue
+		}
+		_, body, err := readObject(repo, t, shaString)
+		if err != nil {
+			return "", fmt.Errorf("show %s: %w", shaString, err)
+		}
+		return string(body), nil
 	}
 
-	if exists(path.Join(got.TreeDirAbsPath(), shaString)) {
-		return objContent(path.Join(got.TreeDirAbsPath(), shaString))
+	return "", fmt.Errorf("show %s: %w", rev, got.ErrObjDoesNotExist)
+}
+
+// showCommit returns commit info with its parent, author and committer, followed by the commit
+// message and content.
+func showCommit(repo *got.Repository, hashString string) (string, error) {
+	h, body, err := readObject(repo, Commit, hashString)
+	if err != nil {
+		return "", fmt.Errorf("show commit %s: %w", hashString, err)
 	}
 
-	if exists(path.Join(got.TreeDirAbsPath(), shaString)) {
-		return objContent(path.Join(got.TreeDirAbsPath(), shaString))
+	info := []string{"commit " + hashString}
+	for _, p := range h.Parents {
+		info = append(info, "parent "+p)
 	}
+	if person := signaturePerson(h.Author); person != "" {
+		info = append(info, "author "+person)
+	}
+	if person := signaturePerson(h.Committer); person != "" {
+		info = append(info, "committer "+person)
+	}
+	info = append(info, "date "+headerTime(h).UTC().Format(time.RFC3339), "", h.Message, "", string(body))
+	return strings.Join(info, "\n"), nil
+}
 
-	log.Fatal(got.ErrObjDoesNotExist)
-	panic("never reach")
+// signaturePerson returns a signature name and email, or an empty string for signatures
+// of commits made before authors were recorded.
+func signaturePerson(sig got.Signature) string {
+	if sig.Name == "" && sig.Email == "" {
+		return ""
+	}
+	return sig.Name + " <" + sig.Email + ">"
 }
 
-// objContent reads object archive and returns only its contentw without gzip headers.
-func objContent(p string) string {
-	res, _ := readArchive(p)
-	return string(res)
+// ResolveCommit turns a revision (branch name, tag name or commit hash) into a commit hash
+// peeling annotated tags down to commits they point to.
+func ResolveCommit(repo *got.Repository, rev string) (string, error) {
+	hash, err := repo.ReadRef(rev)
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", rev, err)
+	}
+
+	for {
+		isTag, err := repo.Store.Has(Tag.toString(), hash)
+		if err != nil {
+			return "", fmt.Errorf("resolve %s: %w", rev, err)
+		}
+		if !isTag {
+			break
+		}
+		tag, err := RecReadObject(repo, Tag, hash, nil)
+		if err != nil {
+			return "", fmt.Errorf("resolve %s: %w", rev, err)
+		}
+		hash = tag.TargetHash
+	}
+
+	ok, err := repo.Store.Has(Commit.toString(), hash)
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", rev, err)
+	}
+	if !ok {
+		return "", fmt.Errorf("resolve %s: %w", rev, got.ErrUnknownRevision)
+	}
+	return hash, nil
 }
 
 // RecRestoreFromObject recursively writes objects into files/folders making an object graph
 // persisted in a worktree.
-func (o *Object) RecRestoreFromObject(p string) {
+func (o *Object) RecRestoreFromObject(p string) error {
 	switch o.ObjType {
 	case Commit:
 		for _, ch := range o.Children {
-			ch.RecRestoreFromObject(p)
+			if err := ch.RecRestoreFromObject(p); err != nil {
+				return err
+			}
 		}
 	case Tree:
 		treePath := path.Join(p, o.Name)
-		err := os.Mkdir(treePath, 0755)
-		if err != nil {
-			log.Fatal(err)
+		// the dir could be kept in the worktree because of ignored files inside
+		if err := os.Mkdir(treePath, ModeDir.OSMode().Perm()); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("restore tree %s: %w", o.HashString, err)
 		}
 		for _, ch := range o.Children {
-			ch.RecRestoreFromObject(treePath)
+			if err := ch.RecRestoreFromObject(treePath); err != nil {
+				return err
+			}
 		}
 	case Blob:
 		blobPath := path.Join(p, o.Name)
-		if err := ioutil.WriteFile(blobPath, []byte(o.gzipContent), 0644); err != nil {
-			log.Fatal(err)
+		if err := o.restoreBlob(blobPath); err != nil {
+			return fmt.Errorf("restore blob %s: %w", o.HashString, err)
 		}
+	default:
+		return fmt.Errorf("RecRestoreFromObject(): %w", got.ErrInvalidObjType)
+	}
+
+	return nil
+}
+
+// restoreBlob writes blob content into a file with the blob mode, or makes a symlink
+// pointing to the content for symlink blobs.
+func (o *Object) restoreBlob(p string) error {
+	if o.Mode == ModeSymlink {
+		return os.Symlink(o.gzipContent, p)
+	}
+
+	perm := o.Mode.OSMode().Perm()
+	if err := ioutil.WriteFile(p, []byte(o.gzipContent), perm); err != nil {
+		return err
 	}
+	// file could exist before with other permissions and umask could cut them off
+	return os.Chmod(p, perm)
 }
 
 // RecReadObject recursively reads objects archives and links them into an object graph.
-func RecReadObject(t ObjectType, hashString string, parentObj *Object) *Object {
+func RecReadObject(repo *got.Repository, t ObjectType, hashString string, parentObj *Object) (*Object, error) {
 	switch t {
 	case Commit:
-		oPath := path.Join(t.storePath(), hashString)
-		res, header := readArchive(oPath)
+		h, body, err := readObject(repo, t, hashString)
+		if err != nil {
+			return nil, err
+		}
 		commit := &Object{
 			ObjType:       Commit,
-			Name:          header.Name,
+			Name:          h.Name,
 			sha:           []byte(hashString),
 			HashString:    hashString,
-			Timestamp:     header.ModTime,
-			CommitMessage: header.Comment,
+			Timestamp:     headerTime(h),
+			CommitMessage: h.Message,
+			Author:        h.Author,
+			Committer:     h.Committer,
+		}
+		if len(h.Parents) > 0 {
+			commit.ParentCommitHash = h.Parents[0]
+		}
+		children, err := parseObjContent(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("read commit %s: %w", hashString, err)
 		}
-		children := parseObjContent(string(res))
 		for _, child := range children {
-			if child.t != Commit {
-				commit.Children = append(commit.Children, RecReadObject(child.t, child.hashString, commit))
-			} else {
+			if child.t == Commit {
 				continue // skip parent commit entry in commit content
 			}
+			childObj, err := RecReadObject(repo, child.t, child.hashString, commit)
+			if err != nil {
+				return nil, err
+			}
+			// the same object could be stored under different names, parent entry keeps the actual one
+			childObj.Name = child.name
+			childObj.Mode = child.mode
+			commit.Children = append(commit.Children, childObj)
 		}
-		return commit
+		return commit, nil
 	case Tree:
-		oPath := path.Join(t.storePath(), hashString)
-		res, header := readArchive(oPath)
+		h, body, err := readObject(repo, t, hashString)
+		if err != nil {
+			return nil, err
+		}
 		tree := &Object{
 			ObjType:    Tree,
-			Name:       header.Name,
+			Name:       h.Name,
 			sha:        []byte(hashString),
 			HashString: hashString,
 			Parent:     parentObj,
-			Timestamp:  header.ModTime,
+			Timestamp:  h.Time,
+		}
+		children, err := parseObjContent(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("read tree %s: %w", hashString, err)
 		}
-		children := parseObjContent(string(res))
 		for _, child := range children {
-			tree.Children = append(tree.Children, RecReadObject(child.t, child.hashString, tree))
+			childObj, err := RecReadObject(repo, child.t, child.hashString, tree)
+			if err != nil {
+				return nil, err
+			}
+			// the same object could be stored under different names, parent entry keeps the actual one
+			childObj.Name = child.name
+			childObj.Mode = child.mode
+			tree.Children = append(tree.Children, childObj)
 		}
-		return tree
+		return tree, nil
 	case Blob:
-		oPath := path.Join(t.storePath(), hashString)
-		res, header := readArchive(oPath)
+		h, body, err := readObject(repo, t, hashString)
+		if err != nil {
+			return nil, err
+		}
 		blob := &Object{
 			ObjType:     Blob,
-			Name:        header.Name,
+			Name:        h.Name,
 			sha:         []byte(hashString),
 			HashString:  hashString,
 			Parent:      parentObj,
-			gzipContent: string(res),
-			Timestamp:   header.ModTime,
+			gzipContent: string(body),
+			Timestamp:   h.Time,
+		}
+		return blob, nil
+	case Tag:
+		h, body, err := readObject(repo, t, hashString)
+		if err != nil {
+			return nil, err
+		}
+		targets, err := parseObjContent(string(body))
+		if err != nil || len(targets) != 1 {
+			return nil, fmt.Errorf("read tag %s: %w", hashString, got.ErrInvalidObjType)
+		}
+		tag := &Object{
+			ObjType:       Tag,
+			Name:          h.Name,
+			sha:           []byte(hashString),
+			HashString:    hashString,
+			TargetHash:    targets[0].hashString,
+			CommitMessage: h.Message,
+			Timestamp:     h.Tagger.When,
+			Author:        h.Tagger,
 		}
-		return blob
+		return tag, nil
 	default:
-		log.Fatalf("RecReadObject(): %v", got.ErrInvalidObjType)
+		return nil, fmt.Errorf("RecReadObject(): %w", got.ErrInvalidObjType)
 	}
-	panic("never reach")
 }
 
 // objRepr is a local type to proceed object string representation for the further transformation intro and object.
@@ -154,48 +311,65 @@ type objRepr struct {
 	t          ObjectType
 	hashString string
 	name       string
+	mode       FileMode
 }
 
 // parseObjContent takes object (commit or tree) contents and returns a slice of containing objects
 // in a special representation form.
-func parseObjContent(s string) []objRepr {
+func parseObjContent(s string) ([]objRepr, error) {
 	var objects []objRepr
 	lines := strings.Split(s, "\n")
 	for _, line := range lines {
-		objects = append(objects, parseObjString(line))
+		if line == "" {
+			continue
+		}
+		repr, err := parseObjString(line)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, repr)
 	}
-	return objects
+	return objects, nil
 }
 
 // parseObjString parses object string representation.
-func parseObjString(s string) objRepr {
+func parseObjString(s string) (objRepr, error) {
 	entries := strings.Split(s, "\t")
+	if len(entries) < 2 {
+		return objRepr{}, fmt.Errorf("parse object entry %q: %w", s, got.ErrInvalidObjType)
+	}
 	var name string
-	if len(entries) > 3 {
+	if len(entries) > 2 {
 		name = entries[2]
 	}
-	return objRepr{t: strToObjType(entries[0]), hashString: entries[1], name: name}
+	t, err := strToObjType(entries[0])
+	if err != nil {
+		return objRepr{}, err
+	}
+	repr := objRepr{t: t, hashString: entries[1], name: name}
+	// objects written before modes were recorded have no mode entry
+	switch {
+	case len(entries) > 3:
+		if repr.mode, err = parseMode(entries[3]); err != nil {
+			return objRepr{}, err
+		}
+	case t == Tree:
+		repr.mode = ModeDir
+	case t == Blob:
+		repr.mode = ModeRegular
+	}
+	return repr, nil
 }
 
-// storePath returns objects path to write into depending on its type.
-func (t ObjectType) storePath() string {
-	switch t {
-	case Commit:
-		return got.CommitDirAbsPath()
-	case Tree:
-		return got.TreeDirAbsPath()
-	case Blob:
-		return got.BlobDirAbsPath()
-	default:
-		log.Fatalf("storePath(): %v", got.ErrInvalidObjType)
-	}
-	panic("never reach")
+// Content returns object contents read from the object store.
+func (o *Object) Content() []byte {
+	return []byte(o.gzipContent)
 }
 
 // LogEntry function returns a string representation of a commit for repo commit log.
-func (o *Object) LogEntry() string {
+func (o *Object) LogEntry() (string, error) {
 	if o.ObjType != Commit {
-		log.Fatal(got.ErrWrongLogEntryType)
+		return "", got.ErrWrongLogEntryType
 	}
 
 	logEntry := strings.Join(
@@ -207,142 +381,162 @@ func (o *Object) LogEntry() string {
 		},
 		"\t",
 	)
-	return logEntry + "\n"
+	return logEntry + "\n", nil
 }
 
-// RecCalcHashSum recursively calculates all objects sha1 in an object graph started from very far children
-// and puts it into the object struct fields sha and HashString.
-func (o *Object) RecCalcHashSum() {
+// RecCalcHashSum recursively calculates all objects hashes in an object graph started from very far children
+// and puts it into the object struct fields sha and HashString. Blobs are hashed concurrently first,
+// trees and commits go after them, so their hashes do not depend on the hashing order.
+func (o *Object) RecCalcHashSum(repo *got.Repository) error {
+	if err := HashBlobs(repo, o.blobs()); err != nil {
+		return err
+	}
+	return o.recCalcHashSum(repo)
+}
+
+// recCalcHashSum recursively calculates hashes of objects in a graph.
+func (o *Object) recCalcHashSum(repo *got.Repository) error {
 	switch o.ObjType {
 	case Commit:
 		for _, ch := range o.Children {
-			ch.RecCalcHashSum()
+			if err := ch.recCalcHashSum(repo); err != nil {
+				return err
+			}
 			o.contentLines = append(o.contentLines, ch.buildContentLineForParent())
 		}
-		if o.ParentCommitHash != string(got.EmptyCommitRef) {
-			parentCommitLine := parentCommitShaContentLine(o.ParentCommitHash)
+		if o.ParentCommitHash != repo.EmptyRef() {
+			parentCommitLine, err := parentCommitShaContentLine(repo, o.ParentCommitHash)
+			if err != nil {
+				return err
+			}
 			o.contentLines = append(o.contentLines, parentCommitLine)
 		}
 		sort.Strings(o.contentLines)
 		o.gzipContent = strings.Join(o.contentLines, "\n")
-		data := []byte(o.gzipContent)
-		o.writeShaSum(data)
+		// the header is hashed along with the content, commits of the same tree made by
+		// different people or with different messages are different commits
+		data, err := got.EncodeObject(o.commitHeader(repo), []byte(o.gzipContent))
+		if err != nil {
+			return fmt.Errorf("hash commit: %w", err)
+		}
+		o.writeShaSum(repo, data)
 	case Tree:
 		for _, ch := range o.Children {
-			ch.RecCalcHashSum()
+			if err := ch.recCalcHashSum(repo); err != nil {
+				return err
+			}
 			o.contentLines = append(o.contentLines, ch.buildContentLineForParent())
 		}
 		sort.Strings(o.contentLines)
 		o.gzipContent = strings.Join(o.contentLines, "\n")
 		data := []byte(o.gzipContent)
-		o.writeShaSum(data)
+		o.writeShaSum(repo, data)
 	case Blob:
-		data, err := ioutil.ReadFile(o.Path)
+		// blobs staged in the index already know their hash
+		if o.HashString != "" {
+			return nil
+		}
+		data, err := o.readWorktreeFile(repo)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("hash blob %s: %w", o.Path, err)
 		}
-		o.writeShaSum(data)
+		o.writeShaSum(repo, data)
 	default:
-		log.Fatalf("RecCalcHashSum(): %v", got.ErrInvalidObjType)
+		return fmt.Errorf("recCalcHashSum(): %w", got.ErrInvalidObjType)
 	}
+
+	return nil
 }
 
-// writeShaSum takes bytes data, calculates sha sum for it and writes sum and hash string into the object struct.
-func (o *Object) writeShaSum(data []byte) {
-	h := sha1.New()
-	h.Write(data)
-	o.sha = h.Sum(nil)
+// writeShaSum takes bytes data, calculates its sum with the repo hash algorithm and writes sum and hash
+// string into the object struct.
+func (o *Object) writeShaSum(repo *got.Repository, data []byte) {
+	o.sha = repo.Hash.Sum(data)
 	o.HashString = hashString(o.sha)
 }
 
 // buildContentLineForParent builds a string to put into parents (commit or tree) content to be archived.
 func (o *Object) buildContentLineForParent() string {
 	entries := []string{o.ObjType.toString(), o.HashString, o.Name}
+	if o.Mode != 0 {
+		entries = append(entries, o.Mode.String())
+	}
 	return strings.Join(entries, "\t")
 }
 
+// readWorktreeFile reads contents of a worktree file a blob is built from. Symlink contents
+// is a path it points to.
+func (o *Object) readWorktreeFile(repo *got.Repository) ([]byte, error) {
+	p := filepath.Join(repo.Root, o.Path)
+	if o.Mode == ModeSymlink {
+		target, err := os.Readlink(p)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(target), nil
+	}
+	return ioutil.ReadFile(p)
+}
+
 // parentCommitShaContentLine reads commit archive and builds content line for commit
 // pointing to parent commit.
-func parentCommitShaContentLine(parentHash string) string {
-	parentCommitPath := path.Join(got.CommitDirAbsPath(), parentHash)
-	fd, err := os.Open(parentCommitPath)
+func parentCommitShaContentLine(repo *got.Repository, parentHash string) (string, error) {
+	parent, _, err := readObject(repo, Commit, parentHash)
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("read parent commit %s: %w", parentHash, err)
 	}
-	unarchiver, _ := gzip.NewReader(fd)
-	defer fd.Close()
-	defer unarchiver.Close()
-	entries := []string{Commit.toString(), parentHash, unarchiver.Comment}
-	return strings.Join(entries, "\t")
+	entries := []string{Commit.toString(), parentHash, parent.Message}
+	return strings.Join(entries, "\t"), nil
 }
 
-// RecWriteObjects recursively writes archive for objects in a graph.
-func (o *Object) RecWriteObjects() {
-	if o.ObjType == Commit || o.ObjType == Tree {
-		for _, ch := range o.Children {
-			ch.RecWriteObjects()
-		}
+// RecWriteObjects writes archives for objects in a graph. Objects below the graph root are
+// written concurrently, the root goes last, so a commit is never stored before its trees and blobs.
+func (o *Object) RecWriteObjects(repo *got.Repository) error {
+	if err := WriteObjects(repo, o.descendants()); err != nil {
+		return err
 	}
-
-	o.write()
+	return o.write(repo)
 }
 
 // write function writes archives for objects.
-func (o *Object) write() {
+func (o *Object) write(repo *got.Repository) error {
 	switch o.ObjType {
 	case Commit:
-		path := path.Join(got.CommitDirAbsPath(), o.HashString)
-		writeArchive(path, o.Name, []byte(o.gzipContent), time.Now(), o.CommitMessage)
-		got.UpdateHead(o.HashString)
+		ok, err := repo.Store.Has(Commit.toString(), o.HashString)
+		if err != nil || ok {
+			return err
+		}
+		return putObject(repo, Commit, o.HashString, o.commitHeader(repo), []byte(o.gzipContent))
 	case Tree:
-		path := path.Join(got.TreeDirAbsPath(), o.HashString)
-		if exists(path) {
-			break
+		ok, err := repo.Store.Has(Tree.toString(), o.HashString)
+		if err != nil || ok {
+			return err
 		}
-		writeArchive(path, o.Name, []byte(o.gzipContent), time.Now(), "")
+		h := got.ObjectHeader{Name: o.Name, Time: time.Now()}
+		return putObject(repo, Tree, o.HashString, h, []byte(o.gzipContent))
 	case Blob:
-		path := path.Join(got.BlobDirAbsPath(), o.HashString)
-		if exists(path) {
-			break
+		ok, err := repo.Store.Has(Blob.toString(), o.HashString)
+		if err != nil || ok {
+			return err
 		}
-		data, err := ioutil.ReadFile(o.Path)
+		data, err := o.readWorktreeFile(repo)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("write blob %s: %w", o.Path, err)
 		}
-		writeArchive(path, o.Name, data, time.Now(), "")
+		h := got.ObjectHeader{Name: o.Name, Time: time.Now()}
+		return putObject(repo, Blob, o.HashString, h, data)
 	default:
-		log.Fatalf("write(): %v", got.ErrInvalidObjType)
+		return fmt.Errorf("write(): %w", got.ErrInvalidObjType)
 	}
 }
 
-// writeArchive implements archive writing for object data.
-func writeArchive(p string, name string, data []byte, t time.Time, commitMessage string) {
-	fd, _ := os.Create(p)
-	archiver := gzip.NewWriter(fd)
-	defer fd.Close()
-	defer archiver.Close()
-	archiver.Name = name
-	archiver.ModTime = t
-	if commitMessage != "" {
-		archiver.Comment = commitMessage
-	}
-	archiver.Write(data)
-}
-
-// readArchive reads a gzip archive and returns its content and header struct.
-func readArchive(p string) ([]byte, gzip.Header) {
-	fd, err := os.Open(p)
-	unarchiver, err := gzip.NewReader(fd)
-	if err != nil {
-		log.Fatalf("reading archive %s: %v", p, err)
-	}
-	defer fd.Close()
-	defer unarchiver.Close()
-	res, err := ioutil.ReadAll(unarchiver)
-	if err != nil {
-		log.Fatalf("reading archive %s: %v", p, err)
+// commitHeader returns a header a commit is stored and hashed with.
+func (o *Object) commitHeader(repo *got.Repository) got.ObjectHeader {
+	h := got.ObjectHeader{Type: Commit.toString(), Name: o.Name, Author: o.Author, Committer: o.Committer, Message: o.CommitMessage}
+	if o.ParentCommitHash != "" && o.ParentCommitHash != repo.EmptyRef() {
+		h.Parents = []string{o.ParentCommitHash}
 	}
-	return res, unarchiver.Header
+	return h
 }
 
 // hashString converts hashSum into string representation.
@@ -359,33 +553,25 @@ func (t ObjectType) toString() string {
 		return "tree"
 	case Blob:
 		return "blob"
+	case Tag:
+		return "tag"
 	default:
-		log.Fatalf("toString(): %v", got.ErrInvalidObjType)
-		panic("never reach")
+		return "invalid"
 	}
 }
 
 // strToObjType converts string into respective object type.
-func strToObjType(s string) ObjectType {
+func strToObjType(s string) (ObjectType, error) {
 	switch s {
 	case "commit":
-		return Commit
+		return Commit, nil
 	case "tree":
-		return Tree
+		return Tree, nil
 	case "blob":
-		return Blob
+		return Blob, nil
+	case "tag":
+		return Tag, nil
 	default:
-		log.Fatalf("strToObjType(): %v (%v)", got.ErrInvalidObjType, s)
-		panic("never reach")
-	}
-}
-
-// exists tests wheather a file exists.
-func exists(path string) bool {
-	if _, err := os.Stat(path); err != nil {
-		if os.IsNotExist(err) {
-			return false
-		}
+		return 0, fmt.Errorf("strToObjType(): %w (%v)", got.ErrInvalidObjType, s)
 	}
-	return true
 }
@@ -0,0 +1,68 @@
+package object
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shved/got/got"
+)
+
+// newMemoryRepo inits a repo in a temp dir and replaces its object store with an in-memory one.
+func newMemoryRepo(t *testing.T) (*got.Repository, func()) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	repo, err := got.Init(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("init repo: %v", err)
+	}
+	repo.Store = got.NewMemoryStore()
+	return repo, func() { os.RemoveAll(dir) }
+}
+
+// writeCommit writes files into the worktree root, commits them on top of a parent the way
+// worktree commits do and logs the commit. Refs are left to the caller.
+func writeCommit(t *testing.T, repo *got.Repository, parent, message string, files map[string]string) string {
+	now := time.Now()
+	commit := &Object{ObjType: Commit, CommitMessage: message, Timestamp: now, ParentCommitHash: parent}
+	var err error
+	if commit.Author, err = repo.Author(now); err != nil {
+		t.Fatalf("read author: %v", err)
+	}
+	if commit.Committer, err = repo.Committer(now); err != nil {
+		t.Fatalf("read committer: %v", err)
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(repo.Root, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write %v: %v", name, err)
+		}
+		blob := &Object{ObjType: Blob, Mode: ModeRegular, Name: name, ParentPath: ".", Path: name, Parent: commit}
+		commit.Children = append(commit.Children, blob)
+	}
+	if err := commit.RecCalcHashSum(repo); err != nil {
+		t.Fatalf("hash commit: %v", err)
+	}
+	if err := commit.RecWriteObjects(repo); err != nil {
+		t.Fatalf("write commit: %v", err)
+	}
+
+	entry, err := commit.LogEntry()
+	if err != nil {
+		t.Fatalf("log commit: %v", err)
+	}
+	if err := repo.UpdateLog(entry); err != nil {
+		t.Fatalf("update log: %v", err)
+	}
+	return commit.HashString
+}
+
+// blobHash returns a hash of a blob with given content.
+func blobHash(repo *got.Repository, content string) string {
+	return hashString(repo.Hash.Sum([]byte(content)))
+}
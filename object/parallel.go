@@ -0,0 +1,96 @@
+package object
+
+import (
+	"sync"
+
+	"github.com/shved/got/got"
+)
+
+// forEach runs fn for every object in a pool of repo.Parallelism() goroutines. Objects stop being
+// handed out after the first error, which is returned.
+func forEach(repo *got.Repository, objs []*Object, fn func(o *Object) error) error {
+	workers := repo.Parallelism()
+	if workers > len(objs) {
+		workers = len(objs)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	jobs := make(chan *Object)
+	failed := make(chan struct{})
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range jobs {
+				if err := fn(o); err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, o := range objs {
+		select {
+		case jobs <- o:
+		case <-failed:
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+// HashBlobs calculates hashes of worktree file blobs concurrently. Blobs which already know
+// their hash are skipped.
+func HashBlobs(repo *got.Repository, blobs []*Object) error {
+	var pending []*Object
+	for _, o := range blobs {
+		if o.HashString == "" {
+			pending = append(pending, o)
+		}
+	}
+	return forEach(repo, pending, func(o *Object) error {
+		return o.recCalcHashSum(repo)
+	})
+}
+
+// WriteObjects writes objects into the object store concurrently. Commits and trees are
+// written without their children.
+func WriteObjects(repo *got.Repository, objs []*Object) error {
+	return forEach(repo, objs, func(o *Object) error {
+		return o.write(repo)
+	})
+}
+
+// descendants returns all the objects below an object in a graph, children go before their parents.
+func (o *Object) descendants() []*Object {
+	var objs []*Object
+	for _, ch := range o.Children {
+		objs = append(objs, ch.descendants()...)
+		objs = append(objs, ch)
+	}
+	return objs
+}
+
+// blobs returns all the blobs in a graph started from an object, including the object itself.
+func (o *Object) blobs() []*Object {
+	if o.ObjType == Blob {
+		return []*Object{o}
+	}
+	var blobs []*Object
+	for _, ch := range o.Children {
+		blobs = append(blobs, ch.blobs()...)
+	}
+	return blobs
+}
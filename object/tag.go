@@ -0,0 +1,65 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shved/got/got"
+)
+
+// MakeTag writes an annotated tag object pointing to a commit and returns it. Tag content is
+// a single commit entry line, tag name, message and tagger are kept in the tag header. The tagger
+// is the repo committer identity, so repos not migrated to object headers refuse tags.
+func MakeTag(repo *got.Repository, name, commitHash, message string, t time.Time) (*Object, error) {
+	tagger, err := repo.Committer(t)
+	if err != nil {
+		return nil, fmt.Errorf("make tag %s: %w", name, err)
+	}
+	tag := &Object{
+		ObjType:       Tag,
+		Name:          name,
+		TargetHash:    commitHash,
+		CommitMessage: message,
+		Timestamp:     t,
+		Author:        tagger,
+	}
+
+	target := &Object{ObjType: Commit, HashString: commitHash, Name: name}
+	tag.gzipContent = target.buildContentLineForParent()
+	tag.writeShaSum(repo, []byte(tag.gzipContent))
+
+	h := got.ObjectHeader{Name: name, Tagger: tag.Author, Message: message}
+	if err := putObject(repo, Tag, tag.HashString, h, []byte(tag.gzipContent)); err != nil {
+		return nil, fmt.Errorf("make tag %s: %w", name, err)
+	}
+
+	return tag, nil
+}
+
+// showTag returns annotated tag info followed by the tagged commit content.
+func showTag(repo *got.Repository, hashString string) (string, error) {
+	tag, err := RecReadObject(repo, Tag, hashString, nil)
+	if err != nil {
+		return "", fmt.Errorf("show tag %s: %w", hashString, err)
+	}
+
+	commit, err := Show(repo, tag.TargetHash)
+	if err != nil {
+		return "", err
+	}
+
+	info := strings.Join(
+		[]string{
+			"tag " + tag.Name,
+			"target " + tag.TargetHash,
+			"date " + tag.Timestamp.UTC().Format(time.RFC3339),
+			"",
+			tag.CommitMessage,
+			"",
+			commit,
+		},
+		"\n",
+	)
+	return info, nil
+}
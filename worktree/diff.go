@@ -0,0 +1,246 @@
+package worktree
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/shved/got/diff"
+	"github.com/shved/got/got"
+	"github.com/shved/got/object"
+)
+
+// FileDiff is a change of a single file between two trees. FromPath is empty for added
+// files and ToPath is empty for deleted ones.
+type FileDiff struct {
+	FromPath string
+	ToPath   string
+	FromHash string
+	ToHash   string
+	FromMode object.FileMode
+	ToMode   object.FileMode
+
+	from []byte
+	to   []byte
+}
+
+// IsRename reports whether a file was moved, possibly along with a content change.
+func (d FileDiff) IsRename() bool {
+	return d.FromPath != "" && d.ToPath != "" && d.FromPath != d.ToPath
+}
+
+// Patch returns the file change as a unified diff with a got header.
+func (d FileDiff) Patch() string {
+	fromName, toName := "/dev/null", "/dev/null"
+	if d.FromPath != "" {
+		fromName = "a/" + filepath.ToSlash(d.FromPath)
+	}
+	if d.ToPath != "" {
+		toName = "b/" + filepath.ToSlash(d.ToPath)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diff --got %s %s\n", d.headerPath(d.FromPath, "a/"), d.headerPath(d.ToPath, "b/"))
+
+	switch {
+	case d.FromPath == "":
+		fmt.Fprintf(&sb, "new file mode %s\n", d.ToMode)
+	case d.ToPath == "":
+		fmt.Fprintf(&sb, "deleted file mode %s\n", d.FromMode)
+	default:
+		if d.FromMode != d.ToMode {
+			fmt.Fprintf(&sb, "old mode %s\nnew mode %s\n", d.FromMode, d.ToMode)
+		}
+		if d.IsRename() {
+			fmt.Fprintf(&sb, "rename from %s\nrename to %s\n", filepath.ToSlash(d.FromPath), filepath.ToSlash(d.ToPath))
+		}
+	}
+
+	if bytes.IndexByte(d.from, 0) >= 0 || bytes.IndexByte(d.to, 0) >= 0 {
+		fmt.Fprintf(&sb, "Binary files %s and %s differ\n", fromName, toName)
+		return sb.String()
+	}
+
+	sb.WriteString(diff.Unified(fromName, toName, string(d.from), string(d.to)))
+	return sb.String()
+}
+
+// headerPath returns a prefixed path for the diff header falling back to the other side path.
+func (d FileDiff) headerPath(p, prefix string) string {
+	if p == "" {
+		p = d.FromPath + d.ToPath
+	}
+	return prefix + filepath.ToSlash(p)
+}
+
+// DiffCommits compares trees of two commits given as revisions.
+func DiffCommits(repo *got.Repository, fromRev, toRev string) ([]FileDiff, error) {
+	from, err := commitBlobs(repo, fromRev)
+	if err != nil {
+		return nil, fmt.Errorf("diff: %w", err)
+	}
+	to, err := commitBlobs(repo, toRev)
+	if err != nil {
+		return nil, fmt.Errorf("diff: %w", err)
+	}
+	return diffBlobs(repo, from, to)
+}
+
+// DiffWorktree compares a commit tree given as a revision against the current worktree.
+// An empty revision means HEAD.
+func DiffWorktree(repo *got.Repository, rev string) ([]FileDiff, error) {
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	from := make(map[string]*object.Object)
+	head, err := repo.ReadHead()
+	if err != nil {
+		return nil, fmt.Errorf("diff: %w", err)
+	}
+	if rev != "HEAD" || head != repo.EmptyRef() {
+		if from, err = commitBlobs(repo, rev); err != nil {
+			return nil, fmt.Errorf("diff: %w", err)
+		}
+	}
+
+	objIndex, err := buildObjIndex(repo)
+	if err != nil {
+		return nil, fmt.Errorf("diff: %w", err)
+	}
+	blobs := worktreeBlobs(objIndex)
+	if err := hashBlobsReadOnly(repo, blobs); err != nil {
+		return nil, fmt.Errorf("diff: %w", err)
+	}
+	to := make(map[string]*object.Object)
+	for _, obj := range blobs {
+		to[obj.Path] = obj
+	}
+
+	return diffBlobs(repo, from, to)
+}
+
+// commitBlobs reads a commit graph and returns its blobs indexed by paths.
+func commitBlobs(repo *got.Repository, rev string) (map[string]*object.Object, error) {
+	commitHash, err := object.ResolveCommit(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	wt, err := NewFromCommit(repo, commitHash)
+	if err != nil {
+		return nil, err
+	}
+	return wt.blobs(), nil
+}
+
+// diffBlobs pairs blobs of two trees by path and detects renames among deleted and added
+// blobs having the same content. Result is sorted by path.
+func diffBlobs(repo *got.Repository, from, to map[string]*object.Object) ([]FileDiff, error) {
+	var diffs []FileDiff
+	var deleted, added []string
+
+	for p, fromBlob := range from {
+		toBlob, ok := to[p]
+		if !ok {
+			deleted = append(deleted, p)
+			continue
+		}
+		if fromBlob.HashString != toBlob.HashString || fromBlob.Mode != toBlob.Mode {
+			diffs = append(diffs, FileDiff{
+				FromPath: p,
+				ToPath:   p,
+				FromHash: fromBlob.HashString,
+				ToHash:   toBlob.HashString,
+				FromMode: fromBlob.Mode,
+				ToMode:   toBlob.Mode,
+			})
+		}
+	}
+	for p := range to {
+		if _, ok := from[p]; !ok {
+			added = append(added, p)
+		}
+	}
+	sort.Strings(deleted)
+	sort.Strings(added)
+
+	addedByHash := make(map[string][]string)
+	for _, p := range added {
+		addedByHash[to[p].HashString] = append(addedByHash[to[p].HashString], p)
+	}
+
+	renamed := make(map[string]bool)
+	for _, p := range deleted {
+		hash := from[p].HashString
+		if candidates := addedByHash[hash]; len(candidates) > 0 {
+			diffs = append(diffs, FileDiff{
+				FromPath: p,
+				ToPath:   candidates[0],
+				FromHash: hash,
+				ToHash:   hash,
+				FromMode: from[p].Mode,
+				ToMode:   to[candidates[0]].Mode,
+			})
+			renamed[candidates[0]] = true
+			addedByHash[hash] = candidates[1:]
+			continue
+		}
+		diffs = append(diffs, FileDiff{FromPath: p, FromHash: hash, FromMode: from[p].Mode})
+	}
+	for _, p := range added {
+		if !renamed[p] {
+			diffs = append(diffs, FileDiff{ToPath: p, ToHash: to[p].HashString, ToMode: to[p].Mode})
+		}
+	}
+
+	for i := range diffs {
+		var err error
+		if diffs[i].FromPath != "" {
+			if diffs[i].from, err = blobContent(repo, from[diffs[i].FromPath]); err != nil {
+				return nil, err
+			}
+		}
+		if diffs[i].ToPath != "" {
+			if diffs[i].to, err = blobContent(repo, to[diffs[i].ToPath]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].sortPath() < diffs[j].sortPath() })
+
+	return diffs, nil
+}
+
+// sortPath is a path diffs are ordered by.
+func (d FileDiff) sortPath() string {
+	if d.ToPath != "" {
+		return d.ToPath
+	}
+	return d.FromPath
+}
+
+// blobContent returns blob contents either from the worktree file the blob was built from,
+// or from the object store for blobs read from commits. Symlink contents is a path it points to.
+func blobContent(repo *got.Repository, o *object.Object) ([]byte, error) {
+	if o.Path == "" {
+		return o.Content(), nil
+	}
+	p := filepath.Join(repo.Root, o.Path)
+	if o.Mode == object.ModeSymlink {
+		target, err := os.Readlink(p)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", o.Path, err)
+		}
+		return []byte(target), nil
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", o.Path, err)
+	}
+	return data, nil
+}
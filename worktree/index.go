@@ -0,0 +1,229 @@
+package worktree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shved/got/got"
+	"github.com/shved/got/object"
+)
+
+// NewFromIndex building an object graph from files staged in the repo index.
+func NewFromIndex(repo *got.Repository, commitMessage string, t time.Time) (*Worktree, error) {
+	commit, err := newCommit(repo, commitMessage, t)
+	if err != nil {
+		return nil, err
+	}
+	return newFromIndex(repo, commit)
+}
+
+// newFromIndex builds an object graph of staged files under a given commit root.
+func newFromIndex(repo *got.Repository, commit *object.Object) (*Worktree, error) {
+	idx, err := loadIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+	wt := &Worktree{repo: repo, root: commit}
+
+	trees := make(map[string]bool)
+	for _, e := range idx.Entries() {
+		parentPath := filepath.Dir(e.Path)
+		blob := &object.Object{
+			ObjType:    object.Blob,
+			Mode:       object.ModeFromOS(e.Mode),
+			Name:       filepath.Base(e.Path),
+			ParentPath: parentPath,
+			Path:       e.Path,
+			HashString: e.Hash,
+		}
+		wt.index = append(wt.index, blob)
+
+		for p := parentPath; p != "." && !trees[p]; p = filepath.Dir(p) {
+			trees[p] = true
+			tree := &object.Object{ObjType: object.Tree, Mode: object.ModeDir, Name: filepath.Base(p), ParentPath: filepath.Dir(p), Path: p}
+			wt.index = append(wt.index, tree)
+		}
+	}
+
+	if err := wt.buildWorktreeGraph(); err != nil {
+		return nil, err
+	}
+	if err := wt.buildHashSums(); err != nil {
+		return nil, err
+	}
+	return wt, nil
+}
+
+// Add stages worktree files under given repo relative paths. Blobs of staged files are written
+// into the object store right away, files which are gone from the worktree are unstaged.
+func Add(repo *got.Repository, paths []string) error {
+	return repo.WithLock(func() error {
+		return add(repo, paths)
+	})
+}
+
+// add stages worktree files, the caller holds the repo lock.
+func add(repo *got.Repository, paths []string) error {
+	idx, err := loadIndex(repo)
+	if err != nil {
+		return fmt.Errorf("add: %w", err)
+	}
+
+	objIndex, err := buildObjIndex(repo)
+	if err != nil {
+		return fmt.Errorf("add: %w", err)
+	}
+
+	matched := make(map[string]bool)
+	present := make(map[string]bool)
+
+	var blobs []*object.Object
+	for _, obj := range worktreeBlobs(objIndex) {
+		p, ok := matchPath(obj.Path, paths)
+		if !ok {
+			continue
+		}
+		matched[p] = true
+		present[obj.Path] = true
+		blobs = append(blobs, obj)
+	}
+
+	if err := hashBlobs(repo, blobs, false); err != nil {
+		return fmt.Errorf("add: %w", err)
+	}
+
+	if err := object.WriteObjects(repo, blobs); err != nil {
+		return fmt.Errorf("add: %w", err)
+	}
+
+	for _, obj := range blobs {
+		entry, err := indexEntry(repo, obj)
+		if err != nil {
+			return fmt.Errorf("add: %w", err)
+		}
+		idx.Add(entry)
+	}
+
+	for _, e := range idx.Entries() {
+		if p, ok := matchPath(e.Path, paths); ok && !present[e.Path] {
+			matched[p] = true
+			idx.Remove(e.Path)
+		}
+	}
+
+	for _, p := range paths {
+		if !matched[p] {
+			return fmt.Errorf("add %s: %w", p, got.ErrNoMatchingPath)
+		}
+	}
+
+	return repo.WriteIndex(idx)
+}
+
+// Reset unstages changes under given repo relative paths restoring their index entries
+// from the HEAD commit.
+func Reset(repo *got.Repository, paths []string) error {
+	return repo.WithLock(func() error {
+		return reset(repo, paths)
+	})
+}
+
+// reset unstages changes, the caller holds the repo lock.
+func reset(repo *got.Repository, paths []string) error {
+	idx, err := loadIndex(repo)
+	if err != nil {
+		return fmt.Errorf("reset: %w", err)
+	}
+	headIdx, err := headIndex(repo)
+	if err != nil {
+		return fmt.Errorf("reset: %w", err)
+	}
+
+	for _, e := range idx.Entries() {
+		if _, ok := matchPath(e.Path, paths); ok {
+			idx.Remove(e.Path)
+		}
+	}
+	for _, e := range headIdx.Entries() {
+		if _, ok := matchPath(e.Path, paths); ok {
+			idx.Add(e)
+		}
+	}
+
+	return repo.WriteIndex(idx)
+}
+
+// indexEntry returns an index entry of a hashed worktree file blob.
+func indexEntry(repo *got.Repository, obj *object.Object) (got.IndexEntry, error) {
+	fi, err := os.Lstat(filepath.Join(repo.Root, obj.Path))
+	if err != nil {
+		return got.IndexEntry{}, err
+	}
+	return got.IndexEntry{
+		Path:    obj.Path,
+		Mode:    fi.Mode(),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Hash:    obj.HashString,
+	}, nil
+}
+
+// loadIndex reads the repo index. Repos made before the index was introduced get it
+// built from the HEAD commit.
+func loadIndex(repo *got.Repository) (*got.Index, error) {
+	idx, err := repo.ReadIndex()
+	if errors.Is(err, os.ErrNotExist) {
+		return headIndex(repo)
+	}
+	return idx, err
+}
+
+// headIndex builds an index from the HEAD commit tree. Entries have no file stat info.
+func headIndex(repo *got.Repository) (*got.Index, error) {
+	idx := got.NewIndex()
+
+	head, err := repo.ReadHead()
+	if err != nil {
+		return nil, err
+	}
+	if head == repo.EmptyRef() {
+		return idx, nil
+	}
+
+	wt, err := NewFromCommit(repo, head)
+	if err != nil {
+		return nil, err
+	}
+	for p, blob := range wt.blobs() {
+		idx.Add(got.IndexEntry{Path: p, Mode: blob.Mode.OSMode(), Hash: blob.HashString})
+	}
+	return idx, nil
+}
+
+// writeIndexFromWorktree replaces the index with blobs of a graph restored into the worktree.
+func (wt *Worktree) writeIndexFromWorktree() error {
+	idx := got.NewIndex()
+	for p, blob := range wt.blobs() {
+		fi, err := os.Lstat(filepath.Join(wt.repo.Root, p))
+		if err != nil {
+			return err
+		}
+		idx.Add(got.IndexEntry{Path: p, Mode: fi.Mode(), Size: fi.Size(), ModTime: fi.ModTime(), Hash: blob.HashString})
+	}
+	return wt.repo.WriteIndex(idx)
+}
+
+// matchPath returns the first of given repo relative paths which is a given file path itself
+// or one of its parent dirs. The "." path matches every file.
+func matchPath(p string, paths []string) (string, bool) {
+	for _, prefix := range paths {
+		if prefix == "." || p == prefix || strings.HasPrefix(p, prefix+string(filepath.Separator)) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
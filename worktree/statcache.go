@@ -0,0 +1,84 @@
+package worktree
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/shved/got/got"
+	"github.com/shved/got/object"
+)
+
+// hashBlobs calculates hashes of worktree file blobs. Hashes of files which were not changed since
+// they were hashed last time are taken from the repo stat cache, so these files are not read at all.
+// With prune set the cache is rewritten to hold the given blobs only, which drops files gone from
+// the worktree. The cache is written, so the caller must hold the repo lock.
+func hashBlobs(repo *got.Repository, blobs []*object.Object, prune bool) error {
+	next, err := hashCached(repo, blobs, prune)
+	if err != nil {
+		return err
+	}
+	return repo.WriteStatCache(next)
+}
+
+// hashBlobsReadOnly calculates hashes of worktree file blobs the way hashBlobs does for commands
+// which do not hold the repo lock. The pruned cache is written holding the lock when it is free,
+// so these commands neither wait for other got processes nor fail because of them.
+func hashBlobsReadOnly(repo *got.Repository, blobs []*object.Object) error {
+	next, err := hashCached(repo, blobs, true)
+	if err != nil {
+		return err
+	}
+	err = repo.WithLock(func() error {
+		return repo.WriteStatCache(next)
+	})
+	if errors.Is(err, got.ErrRepoLocked) {
+		return nil
+	}
+	return err
+}
+
+// hashCached calculates hashes of worktree file blobs using the stat cache and returns the cache
+// updated with the blobs.
+func hashCached(repo *got.Repository, blobs []*object.Object, prune bool) (*got.StatCache, error) {
+	cache, err := repo.ReadStatCache()
+	if err != nil {
+		return nil, err
+	}
+	next := cache
+	if prune {
+		next = got.NewStatCache()
+	}
+
+	stats := make([]os.FileInfo, len(blobs))
+	for i, obj := range blobs {
+		fi, err := os.Lstat(filepath.Join(repo.Root, obj.Path))
+		if err != nil {
+			return nil, err
+		}
+		stats[i] = fi
+		if hash, ok := cache.Lookup(obj.Path, fi); ok {
+			obj.HashString = hash
+		}
+	}
+
+	if err := object.HashBlobs(repo, blobs); err != nil {
+		return nil, err
+	}
+
+	for i, obj := range blobs {
+		next.Update(obj.Path, stats[i], obj.HashString)
+	}
+	return next, nil
+}
+
+// worktreeBlobs returns blobs of an object index.
+func worktreeBlobs(objIndex []*object.Object) []*object.Object {
+	var blobs []*object.Object
+	for _, obj := range objIndex {
+		if obj.ObjType == object.Blob {
+			blobs = append(blobs, obj)
+		}
+	}
+	return blobs
+}
@@ -0,0 +1,151 @@
+package worktree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/shved/got/got"
+	"github.com/shved/got/object"
+)
+
+// FileState is a state of a worktree file relative to the HEAD commit.
+type FileState int
+
+const (
+	Added FileState = iota + 1
+	Modified
+	Deleted
+	Touched
+)
+
+// String returns a human readable state name.
+func (s FileState) String() string {
+	switch s {
+	case Added:
+		return "added"
+	case Modified:
+		return "modified"
+	case Deleted:
+		return "deleted"
+	case Touched:
+		return "touched"
+	default:
+		return "unknown"
+	}
+}
+
+// Code returns a single letter state code used in porcelain output.
+func (s FileState) Code() string {
+	switch s {
+	case Added:
+		return "A"
+	case Modified:
+		return "M"
+	case Deleted:
+		return "D"
+	case Touched:
+		return "T"
+	default:
+		return "?"
+	}
+}
+
+// FileStatus is a worktree file path along with its state.
+type FileStatus struct {
+	Path  string
+	State FileState
+}
+
+// Status compares the worktree against the HEAD commit tree and returns files which are added,
+// modified, deleted or unchanged but touched after the HEAD commit was made. Result is sorted by path.
+func Status(repo *got.Repository) ([]FileStatus, error) {
+	head, err := repo.ReadHead()
+	if err != nil {
+		return nil, fmt.Errorf("status: %w", err)
+	}
+
+	headBlobs := make(map[string]*object.Object)
+	var headTime time.Time
+	if head != repo.EmptyRef() {
+		headWt, err := NewFromCommit(repo, head)
+		if err != nil {
+			return nil, fmt.Errorf("status: %w", err)
+		}
+		headBlobs = headWt.blobs()
+		headTime = headWt.root.Timestamp
+	}
+
+	objIndex, err := buildObjIndex(repo)
+	if err != nil {
+		return nil, fmt.Errorf("status: %w", err)
+	}
+
+	var statuses []FileStatus
+	var tracked []*object.Object
+	seen := make(map[string]bool)
+
+	for _, obj := range worktreeBlobs(objIndex) {
+		seen[obj.Path] = true
+		if _, ok := headBlobs[obj.Path]; ok {
+			tracked = append(tracked, obj)
+		} else {
+			statuses = append(statuses, FileStatus{Path: obj.Path, State: Added})
+		}
+	}
+
+	if err := hashBlobsReadOnly(repo, tracked); err != nil {
+		return nil, fmt.Errorf("status: %w", err)
+	}
+
+	for _, obj := range tracked {
+		headBlob := headBlobs[obj.Path]
+		if obj.HashString != headBlob.HashString || obj.Mode != headBlob.Mode {
+			statuses = append(statuses, FileStatus{Path: obj.Path, State: Modified})
+			continue
+		}
+
+		fi, err := os.Lstat(filepath.Join(repo.Root, obj.Path))
+		if err != nil {
+			return nil, fmt.Errorf("status: %w", err)
+		}
+		// commits made before object headers keep their time in seconds, so both times are
+		// compared with a second precision
+		if fi.ModTime().Truncate(time.Second).After(headTime.Truncate(time.Second)) {
+			statuses = append(statuses, FileStatus{Path: obj.Path, State: Touched})
+		}
+	}
+
+	for p := range headBlobs {
+		if !seen[p] {
+			statuses = append(statuses, FileStatus{Path: p, State: Deleted})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Path < statuses[j].Path })
+
+	return statuses, nil
+}
+
+// blobs returns all the worktree graph blobs indexed by their repo relative paths.
+func (wt *Worktree) blobs() map[string]*object.Object {
+	blobs := make(map[string]*object.Object)
+
+	var walk func(o *object.Object, p string)
+	walk = func(o *object.Object, p string) {
+		for _, ch := range o.Children {
+			chPath := filepath.Join(p, ch.Name)
+			switch ch.ObjType {
+			case object.Tree:
+				walk(ch, chPath)
+			case object.Blob:
+				blobs[chPath] = ch
+			}
+		}
+	}
+	walk(wt.root, "")
+
+	return blobs
+}
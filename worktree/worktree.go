@@ -2,109 +2,329 @@
 package worktree
 
 import (
+	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/shved/got/got"
+	"github.com/shved/got/ignore"
 	"github.com/shved/got/object"
 )
 
 // Worktree is a struct representing worktree with a root of commit object.
 type Worktree struct {
+	repo  *got.Repository
 	root  *object.Object
 	index []*object.Object
 }
 
-// NewFromWorktree building an object graph from current repo worktree state.
-func NewFromWorktree(commitMessage string, t time.Time) *Worktree {
-	commit := &object.Object{ObjType: object.Commit, CommitMessage: commitMessage, Timestamp: t}
-	objIndex := buildObjIndex()
+// NewFromWorktree building an object graph from current repo worktree state. Files which
+// were not changed since they were hashed last time are not read.
+func NewFromWorktree(repo *got.Repository, commitMessage string, t time.Time) (*Worktree, error) {
+	commit, err := newCommit(repo, commitMessage, t)
+	if err != nil {
+		return nil, err
+	}
+	objIndex, err := buildObjIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+	if err := hashBlobs(repo, worktreeBlobs(objIndex), true); err != nil {
+		return nil, err
+	}
 	wt := new(Worktree)
+	wt.repo = repo
 	wt.root = commit
 	wt.index = objIndex
-	wt.buildWorktreeGraph()
-	wt.buildHashSums()
-	return wt
+	if err := wt.buildWorktreeGraph(); err != nil {
+		return nil, err
+	}
+	if err := wt.buildHashSums(); err != nil {
+		return nil, err
+	}
+	return wt, nil
 }
 
-// NewFromCommit building an object graph from archived commit object.
-func NewFromCommit(commitHash string) *Worktree {
-	commit := object.RecReadObject(object.Commit, commitHash, &object.Object{})
-	return &Worktree{root: commit}
+// newCommit returns a commit graph root signed with the repo identity.
+func newCommit(repo *got.Repository, commitMessage string, t time.Time) (*object.Object, error) {
+	author, err := repo.Author(t)
+	if err != nil {
+		return nil, err
+	}
+	committer, err := repo.Committer(t)
+	if err != nil {
+		return nil, err
+	}
+	return &object.Object{ObjType: object.Commit, CommitMessage: commitMessage, Timestamp: t, Author: author, Committer: committer}, nil
 }
 
-// MakeCommit builds a worktree from current worktree state and writes obejcts in repo.
-func MakeCommit(message string, t time.Time) {
-	wt := NewFromWorktree(message, t)
-	wt.persistObjects()
-	got.UpdateLog(wt.root.LogEntry())
+// NewFromCommit building an object graph from archived commit object.
+func NewFromCommit(repo *got.Repository, commitHash string) (*Worktree, error) {
+	commit, err := object.RecReadObject(repo, object.Commit, commitHash, &object.Object{})
+	if err != nil {
+		return nil, err
+	}
+	return &Worktree{repo: repo, root: commit}, nil
 }
 
-// ToCommit builds worktree from commit object, erases current worktree state and restore state from commit.
-func ToCommit(commitHash string) {
-	wt := NewFromCommit(commitHash)
-	// TODO insert prompt before rewrite worktree
-	wt.restoreFromObjects()
+// MakeCommit builds a worktree from files staged in the index and writes obejcts in repo.
+// Objects go first and the current branch is advanced last, so an interrupted commit never
+// leaves HEAD pointing to a missing commit.
+func MakeCommit(repo *got.Repository, message string, t time.Time) error {
+	return MakeCommitAs(repo, message, got.Signature{}, t)
 }
 
-// restoreFromObjects erases current worktree and restore objects from a graph.
-func (wt *Worktree) restoreFromObjects() {
-	eraseCurrentWorktree()
-	wt.root.RecRestoreFromObject(got.AbsRepoRoot)
-	got.UpdateHead(wt.root.HashString)
+// MakeCommitAs makes a commit the same way MakeCommit does on behalf of a given author. An empty
+// author means the repo identity, an author without time gets the commit time. Repos not migrated
+// to object headers refuse commits, since older objects have no room for authors.
+func MakeCommitAs(repo *got.Repository, message string, author got.Signature, t time.Time) error {
+	return repo.WithLock(func() error {
+		commit, err := newCommit(repo, message, t)
+		if err != nil {
+			return fmt.Errorf("make commit: %w", err)
+		}
+		// the author is hashed into the commit, so it is set before the graph is built
+		if !author.IsZero() {
+			if author.When.IsZero() {
+				author.When = t
+			}
+			commit.Author = author
+		}
+		wt, err := newFromIndex(repo, commit)
+		if err != nil {
+			return fmt.Errorf("make commit: %w", err)
+		}
+		if err := wt.persistObjects(); err != nil {
+			return fmt.Errorf("make commit: %w", err)
+		}
+		logEntry, err := wt.root.LogEntry()
+		if err != nil {
+			return fmt.Errorf("make commit: %w", err)
+		}
+		if err := repo.UpdateLog(logEntry); err != nil {
+			return fmt.Errorf("make commit: %w", err)
+		}
+		if err := repo.AdvanceHead(wt.root.HashString); err != nil {
+			return fmt.Errorf("make commit: %w", err)
+		}
+		return nil
+	})
 }
 
-// eraseCurrentWorktree erases all the worktree contents.
-func eraseCurrentWorktree() {
-	var paths []string
+// ToCommit builds worktree from a commit object and brings the worktree from the HEAD commit state to it.
+// Only files which differ between the two commits are touched.
+// The revision is either a branch name, which HEAD is switched to, or a tag name or commit hash, which detaches HEAD.
+// Unless forced, checkout is refused with a *LocalChangesError when the worktree has uncommitted changes.
+func ToCommit(repo *got.Repository, rev string, force bool) error {
+	return repo.WithLock(func() error {
+		commitHash, err := object.ResolveCommit(repo, rev)
+		if err != nil {
+			return fmt.Errorf("checkout %s: %w", rev, err)
+		}
 
-	worktreeWalker := func(path string, fi os.FileInfo, err error) error {
+		wt, err := NewFromCommit(repo, commitHash)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("checkout %s: %w", rev, err)
+		}
+		changes, err := localChanges(repo, wt.blobs())
+		if err != nil {
+			return fmt.Errorf("checkout %s: %w", rev, err)
+		}
+		if !force && len(changes) > 0 {
+			return &LocalChangesError{Files: changes}
 		}
 
-		if path == got.AbsRepoRoot {
-			return nil
+		headBlobs, err := headBlobs(repo)
+		if err != nil {
+			return fmt.Errorf("checkout %s: %w", rev, err)
 		}
+		if err := wt.restoreFromObjects(headBlobs, changes); err != nil {
+			return fmt.Errorf("checkout %s: %w", rev, err)
+		}
+		if err := wt.writeIndexFromWorktree(); err != nil {
+			return fmt.Errorf("checkout %s: %w", rev, err)
+		}
+		if repo.IsBranch(rev) {
+			return repo.SetHeadBranch(rev)
+		}
+		return repo.DetachHead(commitHash)
+	})
+}
 
-		for _, entry := range got.DefaultIgnoreEntries {
-			if fi.IsDir() && fi.Name() == entry {
-				return filepath.SkipDir
-			}
+// LocalChangesError is returned when checkout would discard uncommitted worktree changes.
+type LocalChangesError struct {
+	Files []FileStatus
+}
 
-			if !fi.IsDir() && fi.Name() == entry {
-				return nil
-			}
-		}
+// Error lists files with uncommitted changes.
+func (e *LocalChangesError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(got.ErrLocalChanges.Error())
+	sb.WriteString(":")
+	for _, f := range e.Files {
+		fmt.Fprintf(&sb, "\n\t%-9s %s", f.State.String()+":", filepath.ToSlash(f.Path))
+	}
+	return sb.String()
+}
+
+// Unwrap makes the error match got.ErrLocalChanges.
+func (e *LocalChangesError) Unwrap() error {
+	return got.ErrLocalChanges
+}
 
-		paths = append(paths, path)
+// localChanges returns worktree changes which would be lost on checkout to a graph with given
+// blobs. Files which were only touched have their content committed, so they are not counted.
+// Added files are kept by checkout unless the graph has a file in their way.
+func localChanges(repo *got.Repository, blobs map[string]*object.Object) ([]FileStatus, error) {
+	statuses, err := Status(repo)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil
+	var changes []FileStatus
+	for _, st := range statuses {
+		if st.State == Touched || st.State == Added && !collides(st.Path, blobs) {
+			continue
+		}
+		changes = append(changes, st)
 	}
+	return changes, nil
+}
 
-	err := filepath.Walk(got.AbsRepoRoot, worktreeWalker)
+// collides reports whether a path is taken by one of given blobs, either by a blob of the same
+// path, or by a blob in place of one of its dirs, or by blobs under a dir in its place.
+func collides(p string, blobs map[string]*object.Object) bool {
+	for dir := p; dir != "."; dir = filepath.Dir(dir) {
+		if _, ok := blobs[dir]; ok {
+			return true
+		}
+	}
+	prefix := p + string(filepath.Separator)
+	for blobPath := range blobs {
+		if strings.HasPrefix(blobPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// headBlobs returns blobs of the HEAD commit graph indexed by their repo relative paths.
+func headBlobs(repo *got.Repository) (map[string]*object.Object, error) {
+	head, err := repo.ReadHead()
+	if err != nil {
+		return nil, err
+	}
+	if head == repo.EmptyRef() {
+		return make(map[string]*object.Object), nil
+	}
+	wt, err := NewFromCommit(repo, head)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	return wt.blobs(), nil
+}
+
+// restoreFromObjects brings the worktree from the HEAD graph state to the graph state. Only paths
+// which differ between the two graphs or have local changes are removed or rewritten, so identical
+// files keep their contents and mtimes. Added files in changes are in the way of graph files, so
+// they are removed, other files outside of both graphs and ignored files are never touched.
+func (wt *Worktree) restoreFromObjects(headBlobs map[string]*object.Object, changes []FileStatus) error {
+	blobs := wt.blobs()
+
+	changed := make(map[string]bool)
+	for _, st := range changes {
+		changed[st.Path] = true
+	}
+
+	var remove, write []string
+	for p := range headBlobs {
+		if _, ok := blobs[p]; !ok {
+			remove = append(remove, p)
+		}
+	}
+	// changes only keep added files which collide with the graph
+	for p := range changed {
+		_, inHead := headBlobs[p]
+		if _, ok := blobs[p]; !ok && !inHead {
+			remove = append(remove, p)
+		}
+	}
+	for p, blob := range blobs {
+		headBlob, ok := headBlobs[p]
+		if !ok || changed[p] || headBlob.HashString != blob.HashString || headBlob.Mode != blob.Mode {
+			write = append(write, p)
+		}
+	}
+
+	// nested paths go after their parents, so remove them in reverse to clean up dirs on the way
+	sort.Sort(sort.Reverse(sort.StringSlice(remove)))
+	for _, p := range remove {
+		if err := wt.removeFile(p); err != nil {
+			return fmt.Errorf("restore worktree: %w", err)
+		}
+	}
+
+	sort.Strings(write)
+	for _, p := range write {
+		if err := wt.restoreFile(p, blobs[p]); err != nil {
+			return fmt.Errorf("restore worktree: %w", err)
+		}
+	}
+
+	return nil
+}
 
-	for _, p := range paths {
-		_ = os.RemoveAll(p)
+// removeFile removes a repo relative file along with parent dirs left empty.
+func (wt *Worktree) removeFile(p string) error {
+	if err := os.Remove(filepath.Join(wt.repo.Root, p)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for dir := filepath.Dir(p); dir != "."; dir = filepath.Dir(dir) {
+		absDir := filepath.Join(wt.repo.Root, dir)
+		// dirs holding ignored files are kept
+		if empty, _ := isEmpty(absDir); !empty {
+			break
+		}
+		if err := os.Remove(absDir); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// restoreFile writes a blob into a repo relative path replacing a file which is there.
+func (wt *Worktree) restoreFile(p string, blob *object.Object) error {
+	absPath := filepath.Join(wt.repo.Root, p)
+	if err := os.MkdirAll(filepath.Dir(absPath), object.ModeDir.OSMode().Perm()); err != nil {
+		return err
+	}
+	// symlinks can not be overwritten in place and writing a file would follow them
+	if err := os.Remove(absPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return blob.RecRestoreFromObject(filepath.Dir(absPath))
 }
 
 // buildObjIndex reads all the repo worktree and collect files and folders into a slice.
-func buildObjIndex() []*object.Object {
+func buildObjIndex(repo *got.Repository) ([]*object.Object, error) {
 	var objIndex []*object.Object
 
+	matcher, err := newIgnoreMatcher(repo)
+	if err != nil {
+		return nil, err
+	}
+
 	worktreeWalker := func(path string, fi os.FileInfo, err error) error {
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		if path == got.AbsRepoRoot {
+		if path == repo.Root {
 			return nil
 		}
 
@@ -114,30 +334,28 @@ func buildObjIndex() []*object.Object {
 			return filepath.SkipDir
 		}
 
-		for _, entry := range got.DefaultIgnoreEntries {
-			if fi.IsDir() && fi.Name() == entry {
-				return filepath.SkipDir
-			}
-
-			if !fi.IsDir() && fi.Name() == entry {
-				return nil
-			}
+		if skip, err := ignored(matcher, repo, path, fi); skip || err != nil {
+			return err
 		}
 
 		// build object
 		var obj object.Object
 
-		relPath, err := filepath.Rel(got.AbsRepoRoot, path)
+		relPath, err := filepath.Rel(repo.Root, path)
+		if err != nil {
+			return err
+		}
 		parentPath := filepath.Dir(path)
-		relParentPath, err := filepath.Rel(got.AbsRepoRoot, parentPath)
+		relParentPath, err := filepath.Rel(repo.Root, parentPath)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
+		mode := object.ModeFromOS(fi.Mode())
 		if fi.IsDir() {
-			obj = object.Object{ObjType: object.Tree, ParentPath: relParentPath, Name: fi.Name(), Path: relPath}
+			obj = object.Object{ObjType: object.Tree, Mode: mode, ParentPath: relParentPath, Name: fi.Name(), Path: relPath}
 		} else {
-			obj = object.Object{ObjType: object.Blob, ParentPath: relParentPath, Name: fi.Name(), Path: relPath}
+			obj = object.Object{ObjType: object.Blob, Mode: mode, ParentPath: relParentPath, Name: fi.Name(), Path: relPath}
 		}
 
 		objIndex = append(objIndex, &obj)
@@ -145,12 +363,55 @@ func buildObjIndex() []*object.Object {
 		return nil
 	}
 
-	err := filepath.Walk(got.AbsRepoRoot, worktreeWalker)
+	if err := filepath.Walk(repo.Root, worktreeWalker); err != nil {
+		return nil, fmt.Errorf("read worktree: %w", err)
+	}
+
+	return objIndex, nil
+}
+
+// newIgnoreMatcher returns a matcher holding default ignore entries, patterns of the excludes
+// file set in the config and root .gotignore patterns.
+func newIgnoreMatcher(repo *got.Repository) (*ignore.Matcher, error) {
+	entries, err := repo.IgnoreEntries()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	return ignore.New(repo.Root, entries)
+}
+
+// ignored tells a worktree walker whether to skip a path. Walked dirs .gotignore files
+// are loaded into the matcher on the way down, in case the dir itself is not ignored.
+// The returned error is filepath.SkipDir for ignored dirs.
+func ignored(matcher *ignore.Matcher, repo *got.Repository, path string, fi os.FileInfo) (bool, error) {
+	relPath, err := filepath.Rel(repo.Root, path)
+	if err != nil {
+		return true, err
+	}
+	relPath = filepath.ToSlash(relPath)
+
+	if matcher.Ignored(relPath, fi.IsDir()) {
+		if fi.IsDir() {
+			return true, filepath.SkipDir
+		}
+		return true, nil
+	}
+
+	if fi.IsDir() {
+		return false, matcher.AddDir(relPath)
 	}
+	return false, nil
+}
 
-	return objIndex
+// CheckIgnore returns a rule deciding whether a repo relative path is ignored, or nil if no rule matches it.
+func CheckIgnore(repo *got.Repository, p string) (*ignore.Rule, error) {
+	matcher, err := newIgnoreMatcher(repo)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Lstat(filepath.Join(repo.Root, p))
+	isDir := err == nil && fi.IsDir()
+	return matcher.Explain(filepath.ToSlash(p), isDir)
 }
 
 func isEmpty(path string) (bool, error) {
@@ -167,36 +428,45 @@ func isEmpty(path string) (bool, error) {
 	return false, err
 }
 
-func (wt *Worktree) persistObjects() {
-	wt.root.RecWriteObjects()
+func (wt *Worktree) persistObjects() error {
+	return wt.root.RecWriteObjects(wt.repo)
 }
 
-func (wt *Worktree) buildHashSums() {
-	wt.root.RecCalcHashSum()
+func (wt *Worktree) buildHashSums() error {
+	return wt.root.RecCalcHashSum(wt.repo)
 }
 
-// buildWorktreeGraph links objects from object index into a graph structure.
-func (wt *Worktree) buildWorktreeGraph() {
+// buildWorktreeGraph links objects from object index into a graph structure. Trees are indexed
+// by their paths, so every object finds its parent at once.
+func (wt *Worktree) buildWorktreeGraph() error {
 	if wt.root.ObjType != object.Commit {
-		log.Fatal(got.ErrWrongRootType)
+		return got.ErrWrongRootType
 	}
 
-	wt.root.ParentCommitHash = got.ReadHead()
+	head, err := wt.repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	wt.root.ParentCommitHash = head
 
+	trees := make(map[string]*object.Object)
 	for _, obj := range wt.index {
-		if obj.ParentPath == "." {
-			obj.Parent = wt.root
-			wt.root.Children = append(wt.root.Children, obj)
+		if obj.ObjType == object.Tree {
+			trees[obj.Path] = obj
 		}
+	}
 
-		for _, oObj := range wt.index {
-			if oObj.ParentPath == obj.Path {
-				obj.Children = append(obj.Children, oObj)
-			}
-
-			if obj.ParentPath == oObj.Path {
-				obj.Parent = oObj
+	for _, obj := range wt.index {
+		parent := wt.root
+		if obj.ParentPath != "." {
+			var ok bool
+			if parent, ok = trees[obj.ParentPath]; !ok {
+				return fmt.Errorf("link %s: no parent tree %s", obj.Path, obj.ParentPath)
 			}
 		}
+		obj.Parent = parent
+		parent.Children = append(parent.Children, obj)
 	}
+
+	return nil
 }
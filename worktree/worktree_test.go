@@ -0,0 +1,900 @@
+package worktree
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shved/got/got"
+	"github.com/shved/got/object"
+)
+
+// newMemoryRepo inits a repo in a temp dir and replaces its object store with an in-memory one.
+func newMemoryRepo(t testing.TB) (*got.Repository, func()) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	repo, err := got.Init(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("init repo: %v", err)
+	}
+	repo.Store = got.NewMemoryStore()
+	return repo, func() { os.RemoveAll(dir) }
+}
+
+// commitAll stages the whole worktree and commits it.
+func commitAll(repo *got.Repository, message string) error {
+	if err := Add(repo, []string{"."}); err != nil {
+		return err
+	}
+	return MakeCommit(repo, message, time.Now())
+}
+
+func writeFile(t *testing.T, repo *got.Repository, name, content string) {
+	p := filepath.Join(repo.Root, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatalf("create dir for %v: %v", name, err)
+	}
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatalf("write %v: %v", name, err)
+	}
+}
+
+func readFile(t *testing.T, repo *got.Repository, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(repo.Root, name))
+	if err != nil {
+		t.Fatalf("read %v: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestCommitAndCheckoutInMemory(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	writeFile(t, repo, "lib/a.txt", "first")
+	writeFile(t, repo, "b.txt", "second")
+	if err := commitAll(repo, "first"); err != nil {
+		t.Fatalf("make first commit: %v", err)
+	}
+	first, err := repo.ReadHead()
+	if err != nil {
+		t.Fatalf("read head: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(repo.Root, ".got/objects/commit", first)); !os.IsNotExist(err) {
+		t.Fatalf("expected no commit object on disk, got %v", err)
+	}
+
+	writeFile(t, repo, "lib/a.txt", "changed")
+	if err := os.Remove(filepath.Join(repo.Root, "b.txt")); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+	if err := commitAll(repo, "second"); err != nil {
+		t.Fatalf("make second commit: %v", err)
+	}
+
+	if err := ToCommit(repo, first, false); err != nil {
+		t.Fatalf("checkout first commit: %v", err)
+	}
+
+	if content := readFile(t, repo, "lib/a.txt"); content != "first" {
+		t.Fatalf("expected restored content %q, got %q", "first", content)
+	}
+	if content := readFile(t, repo, "b.txt"); content != "second" {
+		t.Fatalf("expected restored content %q, got %q", "second", content)
+	}
+}
+
+func TestSwitchBranches(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	writeFile(t, repo, "a.txt", "master")
+	if err := commitAll(repo, "on master"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	masterHead, _ := repo.ReadHead()
+
+	if err := repo.CreateBranch("feature", masterHead); err != nil {
+		t.Fatalf("create branch: %v", err)
+	}
+	if err := ToCommit(repo, "feature", false); err != nil {
+		t.Fatalf("switch to feature: %v", err)
+	}
+
+	writeFile(t, repo, "a.txt", "feature")
+	if err := commitAll(repo, "on feature"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+
+	if sha, _ := repo.ReadBranch(got.DefaultBranch); sha != masterHead {
+		t.Fatalf("expected %v to stay on %v, got %v", got.DefaultBranch, masterHead, sha)
+	}
+
+	if err := ToCommit(repo, got.DefaultBranch, false); err != nil {
+		t.Fatalf("switch to master: %v", err)
+	}
+	if content := readFile(t, repo, "a.txt"); content != "master" {
+		t.Fatalf("expected %q, got %q", "master", content)
+	}
+	if branch, _ := repo.CurrentBranch(); branch != got.DefaultBranch {
+		t.Fatalf("expected to be on %v, got %v", got.DefaultBranch, branch)
+	}
+}
+
+func TestCheckoutTags(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	writeFile(t, repo, "a.txt", "release")
+	if err := commitAll(repo, "release"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	release, _ := repo.ReadHead()
+
+	if err := repo.CreateTag("v1", release); err != nil {
+		t.Fatalf("create lightweight tag: %v", err)
+	}
+	tag, err := object.MakeTag(repo, "v1-annotated", release, "first release", time.Now())
+	if err != nil {
+		t.Fatalf("make annotated tag: %v", err)
+	}
+	if err := repo.CreateTag("v1-annotated", tag.HashString); err != nil {
+		t.Fatalf("create annotated tag: %v", err)
+	}
+
+	writeFile(t, repo, "a.txt", "development")
+	if err := commitAll(repo, "development"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+
+	for _, name := range []string{"v1", "v1-annotated"} {
+		commitHash, err := object.ResolveCommit(repo, name)
+		if err != nil || commitHash != release {
+			t.Fatalf("expected %v to resolve into %v, got %v, %v", name, release, commitHash, err)
+		}
+
+		if err := ToCommit(repo, got.DefaultBranch, false); err != nil {
+			t.Fatalf("switch to master: %v", err)
+		}
+		if err := ToCommit(repo, name, false); err != nil {
+			t.Fatalf("checkout %v: %v", name, err)
+		}
+		if content := readFile(t, repo, "a.txt"); content != "release" {
+			t.Fatalf("expected %q, got %q", "release", content)
+		}
+		if branch, _ := repo.CurrentBranch(); branch != "" {
+			t.Fatalf("expected detached head after checkout to a tag, got branch %v", branch)
+		}
+	}
+
+	info, err := object.Show(repo, "v1-annotated")
+	if err != nil {
+		t.Fatalf("show annotated tag: %v", err)
+	}
+	if !strings.Contains(info, "first release") || !strings.Contains(info, release) {
+		t.Fatalf("expected tag message and target in tag info, got %q", info)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	writeFile(t, repo, "lib/same.txt", "same")
+	writeFile(t, repo, "lib/touched.txt", "touched")
+	writeFile(t, repo, "modified.txt", "before")
+	writeFile(t, repo, "deleted.txt", "deleted")
+	if err := commitAll(repo, "initial"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+
+	writeFile(t, repo, "modified.txt", "after")
+	writeFile(t, repo, "lib/added.txt", "added")
+	if err := os.Remove(filepath.Join(repo.Root, "deleted.txt")); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(repo.Root, "lib/touched.txt"), future, future); err != nil {
+		t.Fatalf("touch file: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(repo.Root, "lib/same.txt"), past, past); err != nil {
+		t.Fatalf("touch file: %v", err)
+	}
+
+	statuses, err := Status(repo)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+
+	expected := []FileStatus{
+		{Path: "deleted.txt", State: Deleted},
+		{Path: filepath.Join("lib", "added.txt"), State: Added},
+		{Path: filepath.Join("lib", "touched.txt"), State: Touched},
+		{Path: "modified.txt", State: Modified},
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("expected %v, got %v", expected, statuses)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	writeFile(t, repo, "modified.txt", "one\ntwo\n")
+	writeFile(t, repo, "moved.txt", "moved\n")
+	writeFile(t, repo, "deleted.txt", "deleted\n")
+	if err := commitAll(repo, "initial"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	first, _ := repo.ReadHead()
+
+	writeFile(t, repo, "modified.txt", "one\n2\n")
+	writeFile(t, repo, "lib/moved.txt", "moved\n")
+	writeFile(t, repo, "added.txt", "added\n")
+	for _, name := range []string{"moved.txt", "deleted.txt"} {
+		if err := os.Remove(filepath.Join(repo.Root, name)); err != nil {
+			t.Fatalf("remove file: %v", err)
+		}
+	}
+
+	worktreeDiffs, err := DiffWorktree(repo, "")
+	if err != nil {
+		t.Fatalf("diff worktree: %v", err)
+	}
+
+	if err := commitAll(repo, "second"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	commitDiffs, err := DiffCommits(repo, first, got.DefaultBranch)
+	if err != nil {
+		t.Fatalf("diff commits: %v", err)
+	}
+
+	for _, diffs := range [][]FileDiff{worktreeDiffs, commitDiffs} {
+		var patches []string
+		for _, d := range diffs {
+			patches = append(patches, d.Patch())
+		}
+
+		expected := []string{
+			"diff --got a/added.txt b/added.txt\nnew file mode 100644\n--- /dev/null\n+++ b/added.txt\n@@ -0,0 +1,1 @@\n+added\n",
+			"diff --got a/deleted.txt b/deleted.txt\ndeleted file mode 100644\n--- a/deleted.txt\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-deleted\n",
+			"diff --got a/moved.txt b/lib/moved.txt\nrename from moved.txt\nrename to lib/moved.txt\n",
+			"diff --got a/modified.txt b/modified.txt\n--- a/modified.txt\n+++ b/modified.txt\n@@ -1,2 +1,2 @@\n one\n-two\n+2\n",
+		}
+		if !reflect.DeepEqual(patches, expected) {
+			t.Fatalf("expected patches %q, got %q", expected, patches)
+		}
+	}
+}
+
+func TestStaging(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	writeFile(t, repo, "lib/a.txt", "a")
+	writeFile(t, repo, "b.txt", "b")
+	if err := commitAll(repo, "initial"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	first, _ := repo.ReadHead()
+
+	writeFile(t, repo, "lib/a.txt", "changed a")
+	writeFile(t, repo, "b.txt", "changed b")
+	writeFile(t, repo, "c.txt", "c")
+
+	if err := Add(repo, []string{"lib", "c.txt"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := Reset(repo, []string{"c.txt"}); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if err := Add(repo, []string{"missing.txt"}); !errors.Is(err, got.ErrNoMatchingPath) {
+		t.Fatalf("expected %v, got %v", got.ErrNoMatchingPath, err)
+	}
+
+	// staged content is committed even if the file changed after it was added
+	writeFile(t, repo, "lib/a.txt", "changed a again")
+
+	if err := MakeCommit(repo, "partial", time.Now()); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+
+	diffs, err := DiffCommits(repo, first, got.DefaultBranch)
+	if err != nil {
+		t.Fatalf("diff commits: %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].ToPath != filepath.Join("lib", "a.txt") {
+		t.Fatalf("expected only lib/a.txt to be committed, got %+v", diffs)
+	}
+	if !strings.Contains(diffs[0].Patch(), "+changed a\n") {
+		t.Fatalf("expected staged content to be committed, got %q", diffs[0].Patch())
+	}
+
+	if err := os.Remove(filepath.Join(repo.Root, "b.txt")); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+	if err := Add(repo, []string{"b.txt"}); err != nil {
+		t.Fatalf("add removed file: %v", err)
+	}
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %v", err)
+	}
+	if _, ok := idx.Entry("b.txt"); ok {
+		t.Fatal("expected removed file to be unstaged")
+	}
+	if e, ok := idx.Entry(filepath.Join("lib", "a.txt")); !ok || e.Size != int64(len("changed a")) {
+		t.Fatalf("expected staged entry for lib/a.txt, got %+v", e)
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	writeFile(t, repo, ".gotignore", "build/\n*.log\n")
+	writeFile(t, repo, "app/main.go", "package main")
+	writeFile(t, repo, "app/debug.log", "debug")
+	writeFile(t, repo, "build/app", "binary")
+	if err := commitAll(repo, "initial"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	first, _ := repo.ReadHead()
+
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %v", err)
+	}
+	var staged []string
+	for _, e := range idx.Entries() {
+		staged = append(staged, filepath.ToSlash(e.Path))
+	}
+	if !reflect.DeepEqual(staged, []string{".gotignore", "app/main.go"}) {
+		t.Fatalf("expected ignored files not to be staged, got %v", staged)
+	}
+
+	rule, err := CheckIgnore(repo, filepath.Join("build", "app"))
+	if err != nil || rule == nil || rule.String() != ".gotignore:1:build/" {
+		t.Fatalf("expected build/app to be ignored by .gotignore:1:build/, got %v, %v", rule, err)
+	}
+
+	writeFile(t, repo, "app/main.go", "package app")
+	if err := commitAll(repo, "second"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	if err := ToCommit(repo, first, false); err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+
+	for name, content := range map[string]string{"app/debug.log": "debug", "build/app": "binary", "app/main.go": "package main"} {
+		if c := readFile(t, repo, name); c != content {
+			t.Fatalf("expected %v to be %q after checkout, got %q", name, content, c)
+		}
+	}
+}
+
+func TestModesAndSymlinks(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	writeFile(t, repo, "bin/run.sh", "#!/bin/sh\necho run\n")
+	if err := os.Chmod(filepath.Join(repo.Root, "bin/run.sh"), 0755); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	writeFile(t, repo, "config.txt", "config")
+	if err := os.Symlink("config.txt", filepath.Join(repo.Root, "link.txt")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if err := commitAll(repo, "initial"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	first, _ := repo.ReadHead()
+
+	if err := os.Chmod(filepath.Join(repo.Root, "bin/run.sh"), 0644); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	statuses, err := Status(repo)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	expected := []FileStatus{{Path: filepath.Join("bin", "run.sh"), State: Modified}}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("expected %v, got %v", expected, statuses)
+	}
+
+	if err := commitAll(repo, "second"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	if err := ToCommit(repo, first, false); err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+
+	fi, err := os.Lstat(filepath.Join(repo.Root, "bin/run.sh"))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if fi.Mode().Perm() != 0755 {
+		t.Fatalf("expected executable mode to be restored, got %v", fi.Mode())
+	}
+
+	fi, err = os.Lstat(filepath.Join(repo.Root, "link.txt"))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected symlink to be restored, got %v", fi.Mode())
+	}
+	if target, _ := os.Readlink(filepath.Join(repo.Root, "link.txt")); target != "config.txt" {
+		t.Fatalf("expected symlink to point to config.txt, got %q", target)
+	}
+	// symlinks are diffed by their targets, even dangling ones
+	linkPath := filepath.Join(repo.Root, "link.txt")
+	if err := os.Remove(linkPath); err != nil {
+		t.Fatalf("remove symlink: %v", err)
+	}
+	if err := os.Symlink("missing.txt", linkPath); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	diffs, err := DiffWorktree(repo, "")
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	if len(diffs) != 1 || !strings.Contains(diffs[0].Patch(), "-config.txt\n") || !strings.Contains(diffs[0].Patch(), "+missing.txt\n") {
+		t.Fatalf("expected symlink target change, got %v", diffs)
+	}
+}
+
+func TestCheckoutRefusesLocalChanges(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	writeFile(t, repo, "a.txt", "first")
+	writeFile(t, repo, "gone.txt", "first")
+	if err := commitAll(repo, "first"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	first, _ := repo.ReadHead()
+	writeFile(t, repo, "a.txt", "second")
+	if err := os.Remove(filepath.Join(repo.Root, "gone.txt")); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+	if err := commitAll(repo, "second"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+
+	writeFile(t, repo, "a.txt", "uncommitted")
+	writeFile(t, repo, "gone.txt", "untracked")
+	writeFile(t, repo, "new.txt", "untracked")
+
+	err := ToCommit(repo, first, false)
+	if !errors.Is(err, got.ErrLocalChanges) {
+		t.Fatalf("expected %v, got %v", got.ErrLocalChanges, err)
+	}
+	var changesErr *LocalChangesError
+	if !errors.As(err, &changesErr) || len(changesErr.Files) != 2 || changesErr.Files[1].Path != "gone.txt" {
+		t.Fatalf("expected the modified file and the untracked one in the way listed, got %v", err)
+	}
+	if content := readFile(t, repo, "a.txt"); content != "uncommitted" {
+		t.Fatalf("expected local changes to be kept, got %q", content)
+	}
+
+	if err := ToCommit(repo, first, true); err != nil {
+		t.Fatalf("forced checkout: %v", err)
+	}
+	if content := readFile(t, repo, "a.txt"); content != "first" {
+		t.Fatalf("expected %q after forced checkout, got %q", "first", content)
+	}
+	if content := readFile(t, repo, "new.txt"); content != "untracked" {
+		t.Fatalf("expected untracked file to be kept by forced checkout, got %q", content)
+	}
+}
+
+func TestCheckoutTouchesOnlyChangedFiles(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	writeFile(t, repo, "keep.txt", "same")
+	writeFile(t, repo, "lib/a.txt", "first")
+	if err := commitAll(repo, "first"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	first, _ := repo.ReadHead()
+
+	if err := os.RemoveAll(filepath.Join(repo.Root, "lib")); err != nil {
+		t.Fatalf("remove dir: %v", err)
+	}
+	writeFile(t, repo, "lib", "now a file")
+	if err := commitAll(repo, "second"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	second, _ := repo.ReadHead()
+
+	keepPath := filepath.Join(repo.Root, "keep.txt")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(keepPath, old, old); err != nil {
+		t.Fatalf("set mtime: %v", err)
+	}
+
+	if err := ToCommit(repo, first, false); err != nil {
+		t.Fatalf("checkout first: %v", err)
+	}
+	if content := readFile(t, repo, "lib/a.txt"); content != "first" {
+		t.Fatalf("expected %q, got %q", "first", content)
+	}
+	fi, err := os.Stat(keepPath)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if !fi.ModTime().Equal(old) {
+		t.Fatalf("expected unchanged file mtime %v, got %v", old, fi.ModTime())
+	}
+
+	if err := ToCommit(repo, second, false); err != nil {
+		t.Fatalf("checkout second: %v", err)
+	}
+	if content := readFile(t, repo, "lib"); content != "now a file" {
+		t.Fatalf("expected %q, got %q", "now a file", content)
+	}
+}
+
+func TestStatCache(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	writeFile(t, repo, "a.txt", "aaaa")
+	p := filepath.Join(repo.Root, "a.txt")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(p, old, old); err != nil {
+		t.Fatalf("set mtime: %v", err)
+	}
+	if err := commitAll(repo, "first"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+
+	// same size, mtime and inode makes the file look unchanged without reading it
+	writeFile(t, repo, "a.txt", "bbbb")
+	if err := os.Chtimes(p, old, old); err != nil {
+		t.Fatalf("set mtime: %v", err)
+	}
+	statuses, err := Status(repo)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if len(statuses) != 0 {
+		t.Fatalf("expected cached hash to be used, got %v", statuses)
+	}
+
+	if err := os.Remove(repo.StatCachePath()); err != nil {
+		t.Fatalf("remove stat cache: %v", err)
+	}
+	statuses, err = Status(repo)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	expected := []FileStatus{{Path: "a.txt", State: Modified}}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("expected %v without stat cache, got %v", expected, statuses)
+	}
+
+	// read-only commands leave the cache to the got process holding the lock
+	if err := os.Remove(repo.StatCachePath()); err != nil {
+		t.Fatalf("remove stat cache: %v", err)
+	}
+	if err := repo.Lock(); err != nil {
+		t.Fatalf("lock repo: %v", err)
+	}
+	defer repo.Unlock()
+	if _, err := Status(repo); err != nil {
+		t.Fatalf("status of a locked repo: %v", err)
+	}
+	if _, err := DiffWorktree(repo, "HEAD"); err != nil {
+		t.Fatalf("diff of a locked repo: %v", err)
+	}
+	if _, err := os.Stat(repo.StatCachePath()); !os.IsNotExist(err) {
+		t.Fatalf("expected stat cache not to be written without the lock, got %v", err)
+	}
+}
+
+// generateTree writes files of a given size spread over nested dirs into the repo worktree.
+func generateTree(tb testing.TB, repo *got.Repository, files, size int) {
+	data := make([]byte, size)
+	for i := 0; i < files; i++ {
+		p := filepath.Join(repo.Root, fmt.Sprintf("dir%d/sub%d/file%d.txt", i%20, i%7, i))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			tb.Fatalf("create dir: %v", err)
+		}
+		for j := range data {
+			data[j] = byte('a' + (i+j)%26)
+		}
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			tb.Fatalf("write file: %v", err)
+		}
+	}
+}
+
+func TestParallelHashingIsDeterministic(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+	generateTree(t, repo, 300, 512)
+
+	tm := time.Now()
+	var hashes []string
+	for _, workers := range []int{1, 8} {
+		repo.Workers = workers
+		if err := os.RemoveAll(repo.StatCachePath()); err != nil {
+			t.Fatalf("remove stat cache: %v", err)
+		}
+		wt, err := NewFromWorktree(repo, "message", tm)
+		if err != nil {
+			t.Fatalf("build worktree with %d workers: %v", workers, err)
+		}
+		hashes = append(hashes, wt.root.HashString)
+	}
+
+	if hashes[0] != hashes[1] {
+		t.Fatalf("expected the same commit hash for any number of workers, got %v", hashes)
+	}
+}
+
+func BenchmarkCommitLargeTree(b *testing.B) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		b.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	repo, err := got.Init(dir)
+	if err != nil {
+		b.Fatalf("init repo: %v", err)
+	}
+	generateTree(b, repo, 2000, 16<<10)
+	objectsPath := filepath.Join(repo.Root, ".got", "objects")
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			repo.Workers = workers
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := os.RemoveAll(objectsPath); err != nil {
+					b.Fatalf("remove objects: %v", err)
+				}
+				if err := os.RemoveAll(repo.StatCachePath()); err != nil {
+					b.Fatalf("remove stat cache: %v", err)
+				}
+				b.StartTimer()
+
+				wt, err := NewFromWorktree(repo, "message", time.Now())
+				if err != nil {
+					b.Fatalf("build worktree: %v", err)
+				}
+				if err := wt.persistObjects(); err != nil {
+					b.Fatalf("persist objects: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// syntheticIndex returns an object index of files spread over dirs of a hundred files each,
+// dirs are grouped by ten into parent dirs.
+func syntheticIndex(files int) []*object.Object {
+	var objIndex []*object.Object
+	dirs := make(map[string]bool)
+	addTree := func(p string) {
+		if !dirs[p] {
+			dirs[p] = true
+			objIndex = append(objIndex, &object.Object{ObjType: object.Tree, Mode: object.ModeDir, Name: filepath.Base(p), ParentPath: filepath.Dir(p), Path: p})
+		}
+	}
+	for i := 0; i < files; i++ {
+		parent := fmt.Sprintf("group%d", i/1000)
+		dir := filepath.Join(parent, fmt.Sprintf("dir%d", i/100))
+		addTree(parent)
+		addTree(dir)
+		name := fmt.Sprintf("file%d.txt", i)
+		objIndex = append(objIndex, &object.Object{ObjType: object.Blob, Mode: object.ModeRegular, Name: name, ParentPath: dir, Path: filepath.Join(dir, name)})
+	}
+	return objIndex
+}
+
+func TestBuildWorktreeGraph(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+
+	objIndex := syntheticIndex(2500)
+	wt := &Worktree{repo: repo, root: &object.Object{ObjType: object.Commit}, index: objIndex}
+	if err := wt.buildWorktreeGraph(); err != nil {
+		t.Fatalf("build graph: %v", err)
+	}
+
+	if len(wt.root.Children) != 3 {
+		t.Fatalf("expected 3 root trees, got %d", len(wt.root.Children))
+	}
+	blobs := wt.blobs()
+	if len(blobs) != 2500 {
+		t.Fatalf("expected 2500 linked blobs, got %d", len(blobs))
+	}
+	for _, obj := range objIndex {
+		if obj.Parent == nil {
+			t.Fatalf("expected %s to be linked to its parent", obj.Path)
+		}
+		if obj.Parent != wt.root && obj.Parent.Path != obj.ParentPath {
+			t.Fatalf("expected %s parent to be %s, got %s", obj.Path, obj.ParentPath, obj.Parent.Path)
+		}
+	}
+}
+
+func BenchmarkBuildWorktreeGraph(b *testing.B) {
+	repo, cleanup := newMemoryRepo(b)
+	defer cleanup()
+
+	for _, files := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("files=%d", files), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				wt := &Worktree{repo: repo, root: &object.Object{ObjType: object.Commit}, index: syntheticIndex(files)}
+				b.StartTimer()
+
+				if err := wt.buildWorktreeGraph(); err != nil {
+					b.Fatalf("build graph: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSHA256Repo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	repo, err := got.InitWithHash(dir, got.SHA256)
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+
+	writeFile(t, repo, "lib/a.txt", "first")
+	if err := commitAll(repo, "first"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	first, _ := repo.ReadHead()
+	writeFile(t, repo, "lib/a.txt", "second")
+	if err := commitAll(repo, "second"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+
+	if len(first) != 64 {
+		t.Fatalf("expected a sha256 commit hash, got %s", first)
+	}
+	if err := ToCommit(repo, first, false); err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if content := readFile(t, repo, "lib/a.txt"); content != "first" {
+		t.Fatalf("expected %q, got %q", "first", content)
+	}
+	problems, err := object.Fsck(repo)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no fsck problems, got %v, %v", problems, err)
+	}
+}
+
+func TestCommitRefusesLegacyRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	repo, err := got.Init(dir)
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	if err := ioutil.WriteFile(repo.ConfigPath(), []byte("[core]\n\trepositoryformatversion = 0\n"), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if repo, err = got.Open(dir); err != nil {
+		t.Fatalf("open repo: %v", err)
+	}
+
+	writeFile(t, repo, "a.txt", "one")
+	err = commitAll(repo, "first")
+	if !errors.Is(err, got.ErrUnsupportedFormat) || !strings.Contains(err.Error(), "got migrate") {
+		t.Fatalf("expected commit to ask for got migrate, got %v", err)
+	}
+	if head, _ := repo.ReadHead(); head != repo.EmptyRef() {
+		t.Fatalf("expected HEAD not to move, got %s", head)
+	}
+	if repo, err = got.Open(dir); err != nil || repo.ObjectHeaders {
+		t.Fatalf("expected the repo not to be migrated, got %v", err)
+	}
+
+	if _, err := object.MigrateObjects(repo); err != nil {
+		t.Fatalf("migrate objects: %v", err)
+	}
+	if err := commitAll(repo, "first"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	head, _ := repo.ReadHead()
+	if _, err := object.MakeTag(repo, "v1", head, "release", time.Now()); err != nil {
+		t.Fatalf("make tag: %v", err)
+	}
+}
+
+func TestCommitAuthor(t *testing.T) {
+	repo, cleanup := newMemoryRepo(t)
+	defer cleanup()
+	for _, env := range []string{got.EnvAuthorName, got.EnvAuthorEmail, got.EnvCommitterName, got.EnvCommitterEmail} {
+		if old, ok := os.LookupEnv(env); ok {
+			defer os.Setenv(env, old)
+		} else {
+			defer os.Unsetenv(env)
+		}
+	}
+	os.Setenv(got.EnvAuthorName, "Ann")
+	os.Setenv(got.EnvAuthorEmail, "ann@example.com")
+	os.Setenv(got.EnvCommitterName, "Bob")
+	os.Setenv(got.EnvCommitterEmail, "bob@example.com")
+
+	writeFile(t, repo, "a.txt", "one")
+	if err := commitAll(repo, "first"); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	writeFile(t, repo, "a.txt", "two")
+	if err := Add(repo, []string{"."}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := MakeCommitAs(repo, "second", got.Signature{Name: "Eve", Email: "eve@example.com"}, time.Now()); err != nil {
+		t.Fatalf("make commit: %v", err)
+	}
+	head, _ := repo.ReadHead()
+
+	commit, err := object.RecReadObject(repo, object.Commit, head, nil)
+	if err != nil {
+		t.Fatalf("read commit: %v", err)
+	}
+	if commit.Author.Name != "Eve" || commit.Committer.Name != "Bob" || commit.Author.When.IsZero() {
+		t.Fatalf("expected Eve to author and Bob to commit, got %v and %v", commit.Author, commit.Committer)
+	}
+
+	info, err := object.Show(repo, head)
+	if err != nil {
+		t.Fatalf("show commit: %v", err)
+	}
+	if !strings.Contains(info, "author Eve <eve@example.com>\ncommitter Bob <bob@example.com>\n") {
+		t.Fatalf("expected show to print author and committer, got %q", info)
+	}
+	logs, err := object.Log(repo)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	lines := strings.Split(logs, "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "\tEve <eve@example.com>\tsecond") || !strings.Contains(lines[2], "\tAnn <ann@example.com>\tfirst") {
+		t.Fatalf("expected log to print authors newest first, got %q", logs)
+	}
+
+	// commits of the same tree and parent made by different people are different commits
+	hashes := make(map[string]bool)
+	for _, name := range []string{"Alice", "Bob"} {
+		os.Setenv(got.EnvAuthorName, name)
+		wt, err := NewFromIndex(repo, "same", time.Unix(0, 0))
+		if err != nil {
+			t.Fatalf("build commit: %v", err)
+		}
+		hashes[wt.root.HashString] = true
+	}
+	if len(hashes) != 2 {
+		t.Fatalf("expected commits of different authors to get different hashes, got %v", hashes)
+	}
+}